@@ -1,18 +1,23 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"io"
+	"net/http"
+	"os"
 	"strings"
 
 	"github.com/hashicorp/go-hclog"
 	hcplugin "github.com/hashicorp/go-plugin"
 	"github.com/jaegertracing/jaeger/plugin/storage/grpc"
 	"github.com/jaegertracing/jaeger/plugin/storage/grpc/shared"
+	"github.com/jaegertracing/jaeger/proto-gen/storage_v1"
 	"github.com/jaegertracing/jaeger/storage/dependencystore"
 	"github.com/jaegertracing/jaeger/storage/spanstore"
 	otgrpc "github.com/opentracing-contrib/go-grpc"
 	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/viper"
 	jaeger_config "github.com/uber/jaeger-client-go/config"
 	google_grpc "google.golang.org/grpc"
@@ -20,6 +25,8 @@ import (
 	"jaeger-tempo/store"
 )
 
+const capabilitiesMethod = "/jaeger.storage.v1.PluginCapabilities/Capabilities"
+
 func main() {
 	logger := hclog.New(&hclog.LoggerOptions{
 		Name:       "jaeger-tempo",
@@ -53,20 +60,52 @@ func main() {
 	cfg := &store.Config{}
 	cfg.InitFromViper(v)
 
-	backend := store.New(cfg)
-	plugin := &plugin{backend: backend}
-	grpc.ServeWithGRPCServer(&shared.PluginServices{
+	if cfg.MetricsAddress != "" {
+		go serveMetrics(cfg.MetricsAddress, logger)
+	}
+
+	backend, err := store.New(cfg, logger)
+	if err != nil {
+		logger.Error("failed to create tempo backend", "error", err)
+		os.Exit(1)
+	}
+	defer backend.Close()
+
+	archive, err := store.NewArchive(cfg, logger)
+	if err != nil {
+		logger.Error("failed to create archive", "error", err)
+		os.Exit(1)
+	}
+
+	plugin := &plugin{backend: backend, archive: archive}
+	services := &shared.PluginServices{
 		Store: plugin,
-	}, func(options []google_grpc.ServerOption) *google_grpc.Server {
+	}
+	if archive != nil {
+		defer archive.Close()
+		services.ArchiveStore = plugin
+	}
+
+	grpc.ServeWithGRPCServer(services, func(options []google_grpc.ServerOption) *google_grpc.Server {
 		return hcplugin.DefaultGRPCServer([]google_grpc.ServerOption{
-			google_grpc.UnaryInterceptor(otgrpc.OpenTracingServerInterceptor(opentracing.GlobalTracer())),
+			google_grpc.ChainUnaryInterceptor(
+				otgrpc.OpenTracingServerInterceptor(opentracing.GlobalTracer()),
+				capabilitiesInterceptor(plugin),
+			),
 			google_grpc.StreamInterceptor(otgrpc.OpenTracingStreamServerInterceptor(opentracing.GlobalTracer())),
 		})
 	})
 }
 
+var (
+	_ shared.StoragePlugin        = (*plugin)(nil)
+	_ shared.ArchiveStoragePlugin = (*plugin)(nil)
+	_ shared.PluginCapabilities   = (*plugin)(nil)
+)
+
 type plugin struct {
 	backend *store.Backend
+	archive store.Archive
 }
 
 func (p *plugin) DependencyReader() dependencystore.Reader {
@@ -81,6 +120,55 @@ func (p *plugin) SpanWriter() spanstore.Writer {
 	return p.backend
 }
 
+func (p *plugin) ArchiveSpanReader() spanstore.Reader {
+	return p.archive
+}
+
+func (p *plugin) ArchiveSpanWriter() spanstore.Writer {
+	return p.archive
+}
+
+// Capabilities reports the archive features that are actually configured.
+func (p *plugin) Capabilities() (*shared.Capabilities, error) {
+	if p.archive == nil {
+		return &shared.Capabilities{}, nil
+	}
+
+	return &shared.Capabilities{
+		ArchiveSpanReader: true,
+		ArchiveSpanWriter: p.archive.Writable(),
+	}, nil
+}
+
+// capabilitiesInterceptor answers the capabilities RPC from the plugin. Jaeger's own handler only
+// checks whether an archive is registered at all, so it can't tell a read-only archive apart.
+func capabilitiesInterceptor(capabilities shared.PluginCapabilities) google_grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *google_grpc.UnaryServerInfo, handler google_grpc.UnaryHandler) (interface{}, error) {
+		if info.FullMethod != capabilitiesMethod {
+			return handler(ctx, req)
+		}
+
+		c, err := capabilities.Capabilities()
+		if err != nil {
+			return nil, err
+		}
+
+		return &storage_v1.CapabilitiesResponse{
+			ArchiveSpanReader: c.ArchiveSpanReader,
+			ArchiveSpanWriter: c.ArchiveSpanWriter,
+		}, nil
+	}
+}
+
+func serveMetrics(address string, logger hclog.Logger) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	if err := http.ListenAndServe(address, mux); err != nil {
+		logger.Error("failed to serve metrics", "error", err)
+	}
+}
+
 func initJaeger(service string) (io.Closer, error) {
 	// .FromEnv() uses standard environment variables to allow for easy configuration
 	cfg, err := jaeger_config.FromEnv()
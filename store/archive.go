@@ -0,0 +1,175 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/weaveworks/common/user"
+
+	jaeger "github.com/jaegertracing/jaeger/model"
+	jaeger_spanstore "github.com/jaegertracing/jaeger/storage/spanstore"
+)
+
+const (
+	archiveTypeTempo = "tempo"
+	// archiveWALDirectory is the subdirectory of write.wal.directory holding archived spans
+	archiveWALDirectory = "archive"
+)
+
+// Archive stores the traces archived from the Jaeger UI.
+type Archive interface {
+	jaeger_spanstore.Reader
+	jaeger_spanstore.Writer
+	io.Closer
+
+	// Writable reports whether the archive is configured to accept spans.
+	Writable() bool
+}
+
+// NewArchive creates the archive configured in cfg, or returns nil if archiving is disabled.
+func NewArchive(cfg *Config, logger hclog.Logger) (Archive, error) {
+	switch cfg.Archive.Type {
+	case "":
+		return nil, nil
+	case archiveTypeTempo:
+		return newTempoArchive(cfg, logger)
+	case archiveTypeFilesystem:
+		return newFSArchive(cfg.Archive, logger)
+	default:
+		return nil, fmt.Errorf("unknown archive type %q, must be %q or %q", cfg.Archive.Type, archiveTypeTempo, archiveTypeFilesystem)
+	}
+}
+
+// tempoArchive copies archived traces into a Tempo tenant or endpoint with a longer retention and
+// reads them back from there. Without a tenant, the tenant of the request is kept. Archived spans
+// are pushed in batches like any other span.
+type tempoArchive struct {
+	reader   *Backend
+	tenantID string
+	writer   *batchWriter
+}
+
+func newTempoArchive(cfg *Config, logger hclog.Logger) (*tempoArchive, error) {
+	if err := validateTempoArchive(cfg); err != nil {
+		return nil, err
+	}
+
+	// the archive reads through its own backend, sharing the configuration of the main one
+	// except for where it is pointed at and everything that writes or runs in the background
+	readerCfg := *cfg
+	readerCfg.Write = WriteConfig{}
+	readerCfg.Dependencies.SnapshotDirectory = ""
+	readerCfg.Archive = ArchiveConfig{}
+	if cfg.Archive.Backend != "" {
+		readerCfg.Backend = cfg.Archive.Backend
+	}
+
+	reader, err := New(&readerCfg, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive reader: %w", err)
+	}
+
+	a := &tempoArchive{
+		reader:   reader,
+		tenantID: cfg.Archive.Tenant,
+	}
+
+	writeCfg := cfg.Write
+	if cfg.Archive.WriteBackend != "" {
+		writeCfg.Backend = cfg.Archive.WriteBackend
+		writeCfg.Protocol = cfg.Archive.WriteProtocol
+	}
+	// archived spans failing to push are kept apart from the spans of the main writer
+	writeCfg.WAL.Directory = filepath.Join(writeCfg.WAL.Directory, archiveWALDirectory)
+
+	exporter, err := newSpanExporter(writeCfg)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	writer, err := newBatchWriter(writeCfg, exporter, logger)
+	if err != nil {
+		exporter.close()
+		reader.Close()
+		return nil, err
+	}
+	a.writer = writer
+
+	return a, nil
+}
+
+// validateTempoArchive checks that archived traces are read back from where they are written to,
+// away from the traces they were archived from, and that they can't be lost once queued.
+func validateTempoArchive(cfg *Config) error {
+	if (cfg.Archive.Backend == "") != (cfg.Archive.WriteBackend == "") {
+		return fmt.Errorf("archive.backend and archive.write_backend must be set together, so archived traces are read from where they are written to")
+	}
+	if cfg.Archive.Tenant == "" && cfg.Archive.Backend == "" {
+		return fmt.Errorf("archive type %q needs archive.tenant, or archive.backend and archive.write_backend, to be set", archiveTypeTempo)
+	}
+	if cfg.Archive.WriteBackend == "" && cfg.Write.Backend == "" {
+		return fmt.Errorf("archive type %q needs archive.write_backend or write.backend to be set", archiveTypeTempo)
+	}
+	// archived spans are pushed in the background, the wal keeps them until Tempo accepted them
+	if cfg.Write.WAL.Directory == "" {
+		return fmt.Errorf("archive type %q needs write.wal.directory to be set", archiveTypeTempo)
+	}
+	if cfg.Write.Workers < 1 || cfg.Write.BatchSize < 1 {
+		return fmt.Errorf("write.workers and write.batch_size must be at least 1")
+	}
+	return nil
+}
+
+// withTenant points a request at the archive tenant.
+func (a *tempoArchive) withTenant(ctx context.Context) context.Context {
+	if a.tenantID == "" {
+		return ctx
+	}
+	return user.InjectOrgID(ctx, a.tenantID)
+}
+
+func (a *tempoArchive) GetTrace(ctx context.Context, traceID jaeger.TraceID) (*jaeger.Trace, error) {
+	return a.reader.GetTrace(a.withTenant(ctx), traceID)
+}
+
+func (a *tempoArchive) GetServices(ctx context.Context) ([]string, error) {
+	return a.reader.GetServices(a.withTenant(ctx))
+}
+
+func (a *tempoArchive) GetOperations(ctx context.Context, query jaeger_spanstore.OperationQueryParameters) ([]jaeger_spanstore.Operation, error) {
+	return a.reader.GetOperations(a.withTenant(ctx), query)
+}
+
+func (a *tempoArchive) FindTraces(ctx context.Context, query *jaeger_spanstore.TraceQueryParameters) ([]*jaeger.Trace, error) {
+	return a.reader.FindTraces(a.withTenant(ctx), query)
+}
+
+func (a *tempoArchive) FindTraceIDs(ctx context.Context, query *jaeger_spanstore.TraceQueryParameters) ([]jaeger.TraceID, error) {
+	return a.reader.FindTraceIDs(a.withTenant(ctx), query)
+}
+
+func (a *tempoArchive) Writable() bool {
+	return a.writer != nil
+}
+
+// WriteSpan queues an archived span to be pushed to the archive tenant or endpoint. Archiving is
+// best-effort until the push completes: spans that fail to push are kept in the wal and replayed.
+func (a *tempoArchive) WriteSpan(ctx context.Context, span *jaeger.Span) error {
+	if a.writer == nil {
+		return errWriteDisabled
+	}
+
+	return a.writer.enqueue(a.withTenant(ctx), span)
+}
+
+func (a *tempoArchive) Close() error {
+	if a.writer != nil {
+		if err := a.writer.close(); err != nil {
+			return err
+		}
+	}
+	return a.reader.Close()
+}
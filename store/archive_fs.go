@@ -0,0 +1,440 @@
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/opentracing/opentracing-go"
+
+	jaeger "github.com/jaegertracing/jaeger/model"
+	jaeger_spanstore "github.com/jaegertracing/jaeger/storage/spanstore"
+)
+
+const (
+	archiveTypeFilesystem = "filesystem"
+
+	archiveCompressionGzip = "gzip"
+
+	archiveFileExtension     = ".pb"
+	archiveGzipFileExtension = ".pb.gz"
+	// length of the protobuf encoded span that follows, see encodeSpans
+	archiveRecordHeaderSize = 4
+	// archiveCompactMinSize is the size of appended spans below which a compressed file is never
+	// rewritten, so small traces aren't rewritten on every span
+	archiveCompactMinSize = 4 << 10
+)
+
+type archiveKey struct {
+	tenantID string
+	traceID  jaeger.TraceID
+}
+
+type archivedTrace struct {
+	path string
+	size int64
+	// compacted is the size of the file when it was last written as a whole
+	compacted int64
+	modTime   time.Time
+}
+
+// fsArchive keeps archived traces in a local directory, one file per trace and one subdirectory
+// per tenant. Spans are appended to the file of their trace as they are archived, each prefixed
+// with its length, and merged when the trace is read. With compression every append is a gzip
+// member of its own, which barely compresses a single span, so a compressed file is rewritten as
+// a whole once its appended spans take up as much as the rest of it. An in-memory index of the files is built
+// when the archive is opened. Once the files grow beyond maxSize, the least recently archived
+// traces are evicted.
+type fsArchive struct {
+	dir      string
+	compress bool
+	maxSize  int64
+	logger   hclog.Logger
+
+	mtx   sync.Mutex
+	index map[archiveKey]*archivedTrace
+	size  int64
+}
+
+func newFSArchive(cfg ArchiveConfig, logger hclog.Logger) (*fsArchive, error) {
+	switch cfg.Compression {
+	case "", archiveCompressionGzip:
+	default:
+		return nil, fmt.Errorf("unknown archive compression %q, must be empty or %q", cfg.Compression, archiveCompressionGzip)
+	}
+
+	if err := os.MkdirAll(cfg.Directory, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	a := &fsArchive{
+		dir:      cfg.Directory,
+		compress: cfg.Compression == archiveCompressionGzip,
+		maxSize:  cfg.MaxSize,
+		logger:   logger,
+		index:    map[archiveKey]*archivedTrace{},
+	}
+
+	if err := a.buildIndex(); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (a *fsArchive) buildIndex() error {
+	tenantDirs, err := os.ReadDir(a.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read archive directory: %w", err)
+	}
+
+	for _, tenantDir := range tenantDirs {
+		if !tenantDir.IsDir() {
+			continue
+		}
+		tenantID, ok := tenantFromDirectory(tenantDir.Name())
+		if !ok {
+			a.logger.Warn("ignoring unexpected directory in archive directory", "directory", tenantDir.Name())
+			continue
+		}
+
+		files, err := os.ReadDir(filepath.Join(a.dir, tenantDir.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read archive directory: %w", err)
+		}
+
+		for _, file := range files {
+			name := strings.TrimSuffix(strings.TrimSuffix(file.Name(), archiveGzipFileExtension), archiveFileExtension)
+			traceID, err := jaeger.TraceIDFromString(name)
+			if err != nil || name == file.Name() {
+				continue
+			}
+
+			info, err := file.Info()
+			if err != nil {
+				return fmt.Errorf("failed to read archived trace: %w", err)
+			}
+
+			a.index[archiveKey{tenantID: tenantID, traceID: traceID}] = &archivedTrace{
+				path:      filepath.Join(a.dir, tenantDir.Name(), file.Name()),
+				size:      info.Size(),
+				compacted: info.Size(),
+				modTime:   info.ModTime(),
+			}
+			a.size += info.Size()
+		}
+	}
+
+	return nil
+}
+
+func (a *fsArchive) key(ctx context.Context, traceID jaeger.TraceID) archiveKey {
+	tenantID, _ := extractTenantID(ctx)
+	return archiveKey{tenantID: tenantID, traceID: traceID}
+}
+
+func (a *fsArchive) GetTrace(ctx context.Context, traceID jaeger.TraceID) (*jaeger.Trace, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "tempo-query.ArchiveGetTrace")
+	defer span.Finish()
+
+	key := a.key(ctx, traceID)
+	// the file is read without holding the lock, so reads don't wait for writes. Appends and
+	// rewrites leave a readable file at all times, but a rewrite that changes the compression
+	// moves the file, in which case it is looked up again
+	for attempt := 0; ; attempt++ {
+		a.mtx.Lock()
+		entry, ok := a.index[key]
+		var path string
+		if ok {
+			path = entry.path
+		}
+		a.mtx.Unlock()
+		if !ok {
+			return nil, jaeger_spanstore.ErrTraceNotFound
+		}
+
+		trace, err := readArchivedTrace(path)
+		if errors.Is(err, os.ErrNotExist) {
+			if attempt == 0 {
+				continue
+			}
+			// evicted while it was read
+			return nil, jaeger_spanstore.ErrTraceNotFound
+		}
+		return trace, err
+	}
+}
+
+func (a *fsArchive) Writable() bool {
+	return true
+}
+
+// WriteSpan appends a span to the file of its trace.
+func (a *fsArchive) WriteSpan(ctx context.Context, jaegerSpan *jaeger.Span) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "tempo-query.ArchiveWriteSpan")
+	defer span.Finish()
+
+	key := a.key(ctx, jaegerSpan.TraceID)
+	path := a.path(key)
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	entry, ok := a.index[key]
+	if ok && (entry.path != path || a.compress && entry.size-entry.compacted >= maxInt64(entry.compacted, archiveCompactMinSize)) {
+		return a.rewriteTrace(key, entry, path, jaegerSpan)
+	}
+
+	data, err := a.encodeSpans([]*jaeger.Span{jaegerSpan})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write archived trace: %w", err)
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write archived trace: %w", err)
+	}
+
+	if !ok {
+		entry = &archivedTrace{path: path}
+		a.index[key] = entry
+	}
+	entry.size += int64(len(data))
+	entry.modTime = time.Now()
+	a.size += int64(len(data))
+
+	a.evict(key)
+	return nil
+}
+
+// rewriteTrace writes the spans of a trace and a new span to path as a whole, moving the trace
+// there if its file was written with another compression setting.
+func (a *fsArchive) rewriteTrace(key archiveKey, entry *archivedTrace, path string, span *jaeger.Span) error {
+	trace, err := readArchivedTrace(entry.path)
+	if err != nil {
+		return err
+	}
+	size, err := a.writeTrace(path, appendOrReplaceSpan(trace.Spans, span))
+	if err != nil {
+		return err
+	}
+	if entry.path != path {
+		if err := os.Remove(entry.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			a.logger.Warn("failed to remove archived trace", "path", entry.path, "error", err)
+		}
+	}
+
+	a.size += size - entry.size
+	a.index[key] = &archivedTrace{path: path, size: size, compacted: size, modTime: time.Now()}
+	a.evict(key)
+	return nil
+}
+
+func (a *fsArchive) path(key archiveKey) string {
+	extension := archiveFileExtension
+	if a.compress {
+		extension = archiveGzipFileExtension
+	}
+	return filepath.Join(tenantDirectory(a.dir, key.tenantID), key.traceID.String()+extension)
+}
+
+// encodeSpans encodes spans as records to be appended to the file of a trace, compressed as a
+// gzip member of their own if compression is enabled.
+func (a *fsArchive) encodeSpans(spans []*jaeger.Span) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+
+	var gz *gzip.Writer
+	if a.compress {
+		gz = gzip.NewWriter(&buf)
+		w = gz
+	}
+
+	for _, span := range spans {
+		data, err := span.Marshal()
+		if err != nil {
+			return nil, fmt.Errorf("error marshalling archived span: %w", err)
+		}
+
+		var header [archiveRecordHeaderSize]byte
+		binary.BigEndian.PutUint32(header[:], uint32(len(data)))
+		if _, err := w.Write(header[:]); err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+	}
+
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// writeTrace replaces the file of a trace atomically and returns its size.
+func (a *fsArchive) writeTrace(path string, spans []*jaeger.Span) (int64, error) {
+	data, err := a.encodeSpans(spans)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return 0, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return 0, fmt.Errorf("failed to write archived trace: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return 0, fmt.Errorf("failed to write archived trace: %w", err)
+	}
+
+	return int64(len(data)), nil
+}
+
+// evict removes the least recently archived traces until the archive fits in maxSize, never
+// removing the trace that is being written.
+func (a *fsArchive) evict(keep archiveKey) {
+	if a.maxSize <= 0 || a.size <= a.maxSize {
+		return
+	}
+
+	keys := make([]archiveKey, 0, len(a.index))
+	for key := range a.index {
+		if key != keep {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return a.index[keys[i]].modTime.Before(a.index[keys[j]].modTime)
+	})
+
+	for _, key := range keys {
+		if a.size <= a.maxSize {
+			return
+		}
+
+		entry := a.index[key]
+		if err := os.Remove(entry.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			a.logger.Error("failed to evict archived trace", "path", entry.path, "error", err)
+			continue
+		}
+		delete(a.index, key)
+		a.size -= entry.size
+	}
+}
+
+// appendOrReplaceSpan adds a span to spans, replacing a span with the same id.
+func appendOrReplaceSpan(spans []*jaeger.Span, span *jaeger.Span) []*jaeger.Span {
+	for i, s := range spans {
+		if s.SpanID == span.SpanID {
+			spans[i] = span
+			return spans
+		}
+	}
+	return append(spans, span)
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// readArchivedTrace reads the spans appended to the file of a trace. A span archived more than
+// once is only returned in its latest version, so archiving a trace twice doesn't duplicate its
+// spans. A record cut short by a crash while it was appended is ignored.
+func readArchivedTrace(path string) (*jaeger.Trace, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archived trace: %w", err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, archiveGzipFileExtension) {
+		// every append is a gzip member of its own, which the reader concatenates
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress archived trace: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("failed to read archived trace: %w", err)
+	}
+
+	trace := &jaeger.Trace{}
+	positions := map[jaeger.SpanID]int{}
+	for len(data) >= archiveRecordHeaderSize {
+		size := int(binary.BigEndian.Uint32(data))
+		if len(data)-archiveRecordHeaderSize < size {
+			break
+		}
+
+		span := &jaeger.Span{}
+		if err := span.Unmarshal(data[archiveRecordHeaderSize : archiveRecordHeaderSize+size]); err != nil {
+			return nil, fmt.Errorf("error unmarshalling archived span: %w", err)
+		}
+		data = data[archiveRecordHeaderSize+size:]
+
+		if i, ok := positions[span.SpanID]; ok {
+			trace.Spans[i] = span
+			continue
+		}
+		positions[span.SpanID] = len(trace.Spans)
+		trace.Spans = append(trace.Spans, span)
+	}
+
+	return trace, nil
+}
+
+// The archive is only ever read by trace ID, it can't be searched.
+
+func (a *fsArchive) GetServices(ctx context.Context) ([]string, error) {
+	return nil, nil
+}
+
+func (a *fsArchive) GetOperations(ctx context.Context, query jaeger_spanstore.OperationQueryParameters) ([]jaeger_spanstore.Operation, error) {
+	return nil, nil
+}
+
+func (a *fsArchive) FindTraces(ctx context.Context, query *jaeger_spanstore.TraceQueryParameters) ([]*jaeger.Trace, error) {
+	return nil, nil
+}
+
+func (a *fsArchive) FindTraceIDs(ctx context.Context, query *jaeger_spanstore.TraceQueryParameters) ([]jaeger.TraceID, error) {
+	return nil, nil
+}
+
+func (a *fsArchive) Close() error {
+	return nil
+}
@@ -0,0 +1,199 @@
+package store
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/weaveworks/common/user"
+
+	jaeger "github.com/jaegertracing/jaeger/model"
+	jaeger_spanstore "github.com/jaegertracing/jaeger/storage/spanstore"
+)
+
+func TestFSArchiveAppendsSpans(t *testing.T) {
+	for _, compression := range []string{"", archiveCompressionGzip} {
+		t.Run("compression="+compression, func(t *testing.T) {
+			cfg := ArchiveConfig{Directory: t.TempDir(), Compression: compression}
+			a, err := newFSArchive(cfg, hclog.NewNullLogger())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := user.InjectOrgID(context.Background(), "tenant")
+			traceID := jaeger.NewTraceID(0, 1)
+			for id := uint64(1); id <= 3; id++ {
+				span := &jaeger.Span{TraceID: traceID, SpanID: jaeger.NewSpanID(id), OperationName: "op"}
+				if err := a.WriteSpan(ctx, span); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// archiving a span again replaces it
+			if err := a.WriteSpan(ctx, &jaeger.Span{TraceID: traceID, SpanID: jaeger.NewSpanID(2), OperationName: "again"}); err != nil {
+				t.Fatal(err)
+			}
+
+			entry := a.index[a.key(ctx, traceID)]
+			info, err := os.Stat(entry.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() != entry.size || a.size != entry.size {
+				t.Errorf("expected archive size %d to match the file size %d", a.size, info.Size())
+			}
+
+			// reopen to read from a freshly built index
+			a, err = newFSArchive(cfg, hclog.NewNullLogger())
+			if err != nil {
+				t.Fatal(err)
+			}
+			trace, err := a.GetTrace(ctx, traceID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected := []string{"op", "again", "op"}
+			if len(trace.Spans) != len(expected) {
+				t.Fatalf("expected %d spans, got %d", len(expected), len(trace.Spans))
+			}
+			for i, span := range trace.Spans {
+				if span.SpanID != jaeger.NewSpanID(uint64(i+1)) || span.OperationName != expected[i] {
+					t.Errorf("unexpected span %d: %v %s", i, span.SpanID, span.OperationName)
+				}
+			}
+
+			// other tenants don't see the trace
+			if _, err := a.GetTrace(user.InjectOrgID(context.Background(), "other"), traceID); err != jaeger_spanstore.ErrTraceNotFound {
+				t.Errorf("expected trace not found for another tenant, got %v", err)
+			}
+		})
+	}
+}
+
+func TestFSArchiveIgnoresTruncatedSpan(t *testing.T) {
+	cfg := ArchiveConfig{Directory: t.TempDir()}
+	a, err := newFSArchive(cfg, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	traceID := jaeger.NewTraceID(0, 1)
+	for id := uint64(1); id <= 2; id++ {
+		if err := a.WriteSpan(ctx, &jaeger.Span{TraceID: traceID, SpanID: jaeger.NewSpanID(id)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// simulate a crash halfway through appending the second span
+	path := a.index[a.key(ctx, traceID)].path
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-2); err != nil {
+		t.Fatal(err)
+	}
+
+	trace, err := readArchivedTrace(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trace.Spans) != 1 || trace.Spans[0].SpanID != jaeger.NewSpanID(1) {
+		t.Fatalf("expected only the first span, got %v", trace.Spans)
+	}
+}
+
+func TestFSArchiveChangedCompression(t *testing.T) {
+	dir := t.TempDir()
+	a, err := newFSArchive(ArchiveConfig{Directory: dir}, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	traceID := jaeger.NewTraceID(0, 1)
+	if err := a.WriteSpan(ctx, &jaeger.Span{TraceID: traceID, SpanID: jaeger.NewSpanID(1)}); err != nil {
+		t.Fatal(err)
+	}
+	oldPath := a.index[a.key(ctx, traceID)].path
+
+	a, err = newFSArchive(ArchiveConfig{Directory: dir, Compression: archiveCompressionGzip}, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.WriteSpan(ctx, &jaeger.Span{TraceID: traceID, SpanID: jaeger.NewSpanID(2)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(oldPath); !os.IsNotExist(err) {
+		t.Errorf("expected the uncompressed file to be removed, got %v", err)
+	}
+	trace, err := a.GetTrace(ctx, traceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trace.Spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(trace.Spans))
+	}
+}
+
+func TestFSArchiveCompactsCompressedTraces(t *testing.T) {
+	a, err := newFSArchive(ArchiveConfig{Directory: t.TempDir(), Compression: archiveCompressionGzip}, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	traceID := jaeger.NewTraceID(0, 1)
+	newSpan := func(id uint64) *jaeger.Span {
+		return &jaeger.Span{
+			TraceID:       traceID,
+			SpanID:        jaeger.NewSpanID(id),
+			OperationName: "GET /api/v1/orders/{id}",
+			Tags:          []jaeger.KeyValue{jaeger.String("http.url", "https://shop.example.com/api/v1/orders")},
+		}
+	}
+
+	// read while writing, reads don't hold the lock
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			if _, err := a.GetTrace(ctx, traceID); err != nil && err != jaeger_spanstore.ErrTraceNotFound {
+				t.Errorf("failed to read trace while it is written: %v", err)
+				return
+			}
+		}
+	}()
+
+	const spans = 300
+	var uncompacted int
+	for id := uint64(1); id <= spans; id++ {
+		span := newSpan(id)
+		data, err := a.encodeSpans([]*jaeger.Span{span})
+		if err != nil {
+			t.Fatal(err)
+		}
+		uncompacted += len(data)
+
+		if err := a.WriteSpan(ctx, span); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+
+	entry := a.index[a.key(ctx, traceID)]
+	if entry.size > int64(uncompacted)/2 {
+		t.Errorf("expected the compressed trace to be compacted to well below %d bytes, got %d", uncompacted, entry.size)
+	}
+
+	trace, err := a.GetTrace(ctx, traceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trace.Spans) != spans {
+		t.Fatalf("expected %d spans, got %d", spans, len(trace.Spans))
+	}
+}
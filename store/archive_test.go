@@ -0,0 +1,51 @@
+package store
+
+import "testing"
+
+func TestValidateTempoArchive(t *testing.T) {
+	write := WriteConfig{
+		Backend:   "distributor:4317",
+		Workers:   1,
+		BatchSize: 1,
+		WAL:       WALConfig{Directory: "/var/lib/jaeger-tempo/wal"},
+	}
+
+	tests := []struct {
+		name    string
+		archive ArchiveConfig
+		write   WriteConfig
+		valid   bool
+	}{
+		{name: "tenant", archive: ArchiveConfig{Tenant: "archive"}, write: write, valid: true},
+		{name: "separate tempo", archive: ArchiveConfig{Backend: "archive:3200", WriteBackend: "archive:4317"}, write: write, valid: true},
+		{name: "nothing separate", archive: ArchiveConfig{}, write: write},
+		{name: "write backend only", archive: ArchiveConfig{WriteBackend: "archive:4317"}, write: write},
+		{name: "write backend with tenant", archive: ArchiveConfig{Tenant: "archive", WriteBackend: "archive:4317"}, write: write},
+		{name: "read backend only", archive: ArchiveConfig{Backend: "archive:3200"}, write: write},
+		{
+			name:    "no distributor",
+			archive: ArchiveConfig{Tenant: "archive"},
+			write:   WriteConfig{Workers: 1, BatchSize: 1, WAL: write.WAL},
+		},
+		{
+			name:    "archive distributor without write backend",
+			archive: ArchiveConfig{Backend: "archive:3200", WriteBackend: "archive:4317"},
+			write:   WriteConfig{Workers: 1, BatchSize: 1, WAL: write.WAL},
+			valid:   true,
+		},
+		{
+			name:    "no wal",
+			archive: ArchiveConfig{Tenant: "archive"},
+			write:   WriteConfig{Backend: write.Backend, Workers: 1, BatchSize: 1},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateTempoArchive(&Config{Archive: tc.archive, Write: tc.write})
+			if (err == nil) != tc.valid {
+				t.Errorf("expected valid %v, got %v", tc.valid, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,313 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/weaveworks/common/user"
+
+	jaeger "github.com/jaegertracing/jaeger/model"
+)
+
+const (
+	dropReasonQueueFull    = "queue_full"
+	dropReasonExportFailed = "export_failed"
+
+	// shutdownTimeout bounds the pushes still running once the writer is closed, as Jaeger kills
+	// the plugin 2s after asking it to shut down
+	shutdownTimeout = time.Second
+)
+
+var (
+	metricWriteQueueLength = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "jaeger_tempo",
+		Name:      "write_queue_length",
+		Help:      "Number of spans waiting in the write queue.",
+	})
+	metricWriteBatchSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "jaeger_tempo",
+		Name:      "write_batch_size_spans",
+		Help:      "Number of spans per batch pushed to Tempo.",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+	})
+	metricWriteSpansSent = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "jaeger_tempo",
+		Name:      "write_spans_sent_total",
+		Help:      "Total number of spans pushed to Tempo.",
+	})
+	metricWriteSpansDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "jaeger_tempo",
+		Name:      "write_spans_dropped_total",
+		Help:      "Total number of spans dropped by the writer.",
+	}, []string{"reason"})
+)
+
+var errWriteQueueFull = errors.New("span write queue is full")
+
+type queuedSpan struct {
+	tenantID string
+	span     *jaeger.Span
+}
+
+// batchWriter queues spans in memory and pushes them to Tempo in batches from a pool of workers.
+// A batch is flushed once it holds BatchSize spans or its oldest span has waited BatchTimeout.
+// Batches that fail to push are kept in the write-ahead log, if one is configured, and replayed
+// every ReplayInterval.
+type batchWriter struct {
+	exporter      spanExporter
+	wal           *wal
+	logger        hclog.Logger
+	batchSize     int
+	batchTimeout  time.Duration
+	exportTimeout time.Duration
+
+	mtx    sync.RWMutex
+	closed bool
+	queue  chan queuedSpan
+	done   chan struct{}
+	wg     sync.WaitGroup
+
+	// stopCtx is cancelled shortly after close, so pushes can't outlive the plugin
+	stopCtx context.Context
+	stop    context.CancelFunc
+}
+
+func newBatchWriter(cfg WriteConfig, exporter spanExporter, logger hclog.Logger) (*batchWriter, error) {
+	w := &batchWriter{
+		exporter:      exporter,
+		logger:        logger,
+		batchSize:     cfg.BatchSize,
+		batchTimeout:  cfg.BatchTimeout,
+		exportTimeout: cfg.Timeout,
+		queue:         make(chan queuedSpan, cfg.QueueSize),
+		done:          make(chan struct{}),
+	}
+	w.stopCtx, w.stop = context.WithCancel(context.Background())
+
+	if cfg.WAL.Directory != "" {
+		wal, err := openWAL(cfg.WAL, logger)
+		if err != nil {
+			w.stop()
+			return nil, err
+		}
+		w.wal = wal
+
+		w.wg.Add(1)
+		go w.replayLoop(cfg.WAL.ReplayInterval)
+	}
+
+	for i := 0; i < cfg.Workers; i++ {
+		w.wg.Add(1)
+		go w.run()
+	}
+
+	return w, nil
+}
+
+// enqueue adds a span to the queue without blocking, failing if the queue is full.
+func (w *batchWriter) enqueue(ctx context.Context, span *jaeger.Span) error {
+	tenantID, _ := extractTenantID(ctx)
+
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+
+	if w.closed {
+		return errors.New("span writer is closed")
+	}
+
+	select {
+	case w.queue <- queuedSpan{tenantID: tenantID, span: span}:
+		metricWriteQueueLength.Set(float64(len(w.queue)))
+		return nil
+	default:
+		metricWriteSpansDropped.WithLabelValues(dropReasonQueueFull).Inc()
+		return errWriteQueueFull
+	}
+}
+
+func (w *batchWriter) run() {
+	defer w.wg.Done()
+
+	batch := make([]queuedSpan, 0, w.batchSize)
+	timer := time.NewTimer(w.batchTimeout)
+	timer.Stop()
+
+	for {
+		select {
+		case s, ok := <-w.queue:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			metricWriteQueueLength.Set(float64(len(w.queue)))
+
+			if len(batch) == 0 {
+				timer.Reset(w.batchTimeout)
+			}
+			batch = append(batch, s)
+			if len(batch) < w.batchSize {
+				continue
+			}
+
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case <-timer.C:
+		}
+
+		w.flush(batch)
+		batch = make([]queuedSpan, 0, w.batchSize)
+	}
+}
+
+// flush groups the spans of a batch by tenant and process, and pushes one request per tenant.
+func (w *batchWriter) flush(batch []queuedSpan) {
+	if len(batch) == 0 {
+		return
+	}
+	metricWriteBatchSize.Observe(float64(len(batch)))
+
+	byTenant := map[string][]*jaeger.Span{}
+	for _, s := range batch {
+		byTenant[s.tenantID] = append(byTenant[s.tenantID], s.span)
+	}
+
+	for tenantID, spans := range byTenant {
+		// once closed there is no time left to push, spans go straight to the wal
+		if w.closing() && w.wal != nil {
+			if err := w.wal.append(tenantID, spans); err != nil {
+				w.logger.Error("failed to keep spans in the wal on shutdown", "tenant", tenantID, "spans", len(spans), "error", err)
+				metricWriteSpansDropped.WithLabelValues(dropReasonExportFailed).Add(float64(len(spans)))
+			}
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(w.stopCtx, w.exportTimeout)
+		if tenantID != "" {
+			ctx = user.InjectOrgID(ctx, tenantID)
+		}
+
+		err := w.export(ctx, groupByProcess(spans))
+		cancel()
+		if err == nil {
+			metricWriteSpansSent.Add(float64(len(spans)))
+			continue
+		}
+
+		if w.wal != nil {
+			walErr := w.wal.append(tenantID, spans)
+			if walErr == nil {
+				w.logger.Warn("failed to push spans to tempo, kept them in the wal", "tenant", tenantID, "spans", len(spans), "error", err)
+				continue
+			}
+			err = walErr
+		}
+		w.logger.Error("failed to push spans to tempo", "tenant", tenantID, "spans", len(spans), "error", err)
+		metricWriteSpansDropped.WithLabelValues(dropReasonExportFailed).Add(float64(len(spans)))
+	}
+}
+
+func (w *batchWriter) replayLoop(interval time.Duration) {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+
+		if w.wal.empty() {
+			continue
+		}
+
+		err := w.wal.replay(func(tenantID string, spans []*jaeger.Span) error {
+			ctx, cancel := context.WithTimeout(w.stopCtx, w.exportTimeout)
+			defer cancel()
+			if tenantID != "" {
+				ctx = user.InjectOrgID(ctx, tenantID)
+			}
+
+			if err := w.export(ctx, groupByProcess(spans)); err != nil {
+				return err
+			}
+			metricWriteSpansSent.Add(float64(len(spans)))
+			return nil
+		})
+		if err != nil {
+			w.logger.Warn("failed to replay wal, will retry", "error", err)
+		}
+	}
+}
+
+func (w *batchWriter) export(ctx context.Context, batches []*jaeger.Batch) error {
+	traces, err := batchToTraces(batches...)
+	if err != nil {
+		return err
+	}
+	return w.exporter.export(ctx, traces)
+}
+
+// closing reports whether close has been called.
+func (w *batchWriter) closing() bool {
+	select {
+	case <-w.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// close stops accepting spans and waits until every queued span has been flushed. Queued spans
+// are written to the wal if there is one, and pushed to Tempo within shutdownTimeout otherwise.
+// Pushes still running after shutdownTimeout are cancelled, keeping their spans in the wal.
+func (w *batchWriter) close() error {
+	w.mtx.Lock()
+	if w.closed {
+		w.mtx.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.done)
+	close(w.queue)
+	w.mtx.Unlock()
+
+	timer := time.AfterFunc(shutdownTimeout, w.stop)
+	w.wg.Wait()
+	timer.Stop()
+	w.stop()
+
+	if w.wal != nil {
+		if err := w.wal.close(); err != nil {
+			return err
+		}
+	}
+	return w.exporter.close()
+}
+
+// groupByProcess turns spans into one batch per distinct process, which become resource spans in OTLP.
+func groupByProcess(spans []*jaeger.Span) []*jaeger.Batch {
+	var batches []*jaeger.Batch
+	byProcess := map[string]*jaeger.Batch{}
+
+	for _, span := range spans {
+		key := processKey(span.Process)
+		batch, ok := byProcess[key]
+		if !ok {
+			batch = &jaeger.Batch{Process: span.Process}
+			byProcess[key] = batch
+			batches = append(batches, batch)
+		}
+		batch.Spans = append(batch.Spans, span)
+	}
+
+	return batches
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+
+	jaeger "github.com/jaegertracing/jaeger/model"
+
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+// blockingExporter never finishes a push before its context ends.
+type blockingExporter struct{}
+
+func (blockingExporter) export(ctx context.Context, _ pdata.Traces) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (blockingExporter) close() error {
+	return nil
+}
+
+func TestBatchWriterCloseKeepsQueuedSpansInWAL(t *testing.T) {
+	dir := t.TempDir()
+	cfg := WriteConfig{
+		QueueSize:    100,
+		BatchSize:    1,
+		BatchTimeout: time.Hour,
+		Workers:      1,
+		Timeout:      time.Minute,
+		WAL: WALConfig{
+			Directory:      dir,
+			MaxSegmentSize: defaultWALMaxSegmentSize,
+			MaxSize:        defaultWALMaxSize,
+			ReplayInterval: time.Hour,
+		},
+	}
+
+	w, err := newBatchWriter(cfg, blockingExporter{}, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 5; i++ {
+		span := &jaeger.Span{
+			TraceID: jaeger.NewTraceID(0, 1),
+			SpanID:  jaeger.SpanID(i),
+			Process: jaeger.NewProcess("svc", nil),
+		}
+		if err := w.enqueue(context.Background(), span); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := time.Now()
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*shutdownTimeout {
+		t.Fatalf("close took %s, longer than the shutdown timeout allows", elapsed)
+	}
+
+	reopened, err := openWAL(cfg.WAL, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.close()
+
+	var replayed int
+	err = reopened.replay(func(_ string, spans []*jaeger.Span) error {
+		replayed += len(spans)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed != 5 {
+		t.Fatalf("expected 5 spans in the wal, got %d", replayed)
+	}
+}
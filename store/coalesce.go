@@ -0,0 +1,91 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	metricCoalescedRequests = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "jaeger_tempo",
+		Name:      "coalesced_requests_total",
+		Help:      "Total number of requests answered by an identical request already in flight.",
+	})
+)
+
+var errCoalescedCallPanicked = errors.New("coalesced request panicked")
+
+type coalescedCall struct {
+	done   chan struct{}
+	result interface{}
+	err    error
+}
+
+// coalescer lets concurrent identical requests share a single request to Tempo, in the style of
+// singleflight. Requests are keyed by tenant, so tenants never share responses.
+type coalescer struct {
+	mtx   sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+func newCoalescer() *coalescer {
+	return &coalescer{
+		calls: map[string]*coalescedCall{},
+	}
+}
+
+// do runs fn, unless a call for the same request is in flight, in which case its result is
+// returned instead. Results are shared and must not be modified.
+func (c *coalescer) do(ctx context.Context, request string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	tenantID, _ := extractTenantID(ctx)
+	key := tenantID + "\x00" + request
+
+	c.mtx.Lock()
+	if call, ok := c.calls[key]; ok {
+		c.mtx.Unlock()
+		metricCoalescedRequests.Inc()
+
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		// the call ran with the context of the caller that started it, which may have given up
+		if isContextError(call.err) && ctx.Err() == nil {
+			return fn(ctx)
+		}
+		return call.result, call.err
+	}
+
+	// waiters get this error if fn panics
+	call := &coalescedCall{done: make(chan struct{}), err: errCoalescedCallPanicked}
+	c.calls[key] = call
+	c.mtx.Unlock()
+
+	// a panicking fn must not leave the request in flight forever
+	defer func() {
+		c.mtx.Lock()
+		delete(c.calls, key)
+		c.mtx.Unlock()
+		close(call.done)
+	}()
+
+	call.result, call.err = fn(ctx)
+	return call.result, call.err
+}
+
+func isContextError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	// the grpc transport reports them as status errors
+	code := status.Code(err)
+	return code == codes.Canceled || code == codes.DeadlineExceeded
+}
@@ -0,0 +1,56 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCoalescerPanic(t *testing.T) {
+	c := newCoalescer()
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	leaderDone := make(chan interface{})
+	go func() {
+		defer func() { leaderDone <- recover() }()
+		_, _ = c.do(context.Background(), "request", func(ctx context.Context) (interface{}, error) {
+			close(started)
+			<-release
+			panic("boom")
+		})
+	}()
+	<-started
+
+	waiterErr := make(chan error)
+	go func() {
+		_, err := c.do(context.Background(), "request", func(ctx context.Context) (interface{}, error) {
+			return "waiter", nil
+		})
+		waiterErr <- err
+	}()
+
+	// give the waiter time to join the call in flight
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	if recovered := <-leaderDone; recovered != "boom" {
+		t.Fatalf("expected the panic to reach the leader, got %v", recovered)
+	}
+	select {
+	case err := <-waiterErr:
+		if err != errCoalescedCallPanicked {
+			t.Errorf("expected the waiter to get %v, got %v", errCoalescedCallPanicked, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter is stuck on the panicked call")
+	}
+
+	// the request isn't left in flight
+	result, err := c.do(context.Background(), "request", func(ctx context.Context) (interface{}, error) {
+		return "again", nil
+	})
+	if err != nil || result != "again" {
+		t.Fatalf("expected the request to run again, got %v, %v", result, err)
+	}
+}
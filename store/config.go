@@ -1,15 +1,290 @@
 package store
 
 import (
+	"time"
+
 	"github.com/spf13/viper"
 )
 
+const (
+	defaultTransport = transportHTTP
+
+	defaultSearchMaxWindow  = time.Hour
+	defaultSearchTraceHints = 10000
+	defaultSearchWorkers    = 10
+	defaultSearchShards     = 1
+	defaultShardConcurrency = 4
+	defaultOperationsTraces = 20
+
+	defaultTraceCacheTTL          = 10 * time.Minute
+	defaultTraceCacheRecentTTL    = 15 * time.Second
+	defaultTraceCacheRecentWindow = 5 * time.Minute
+
+	defaultValuesCacheIdleTimeout = time.Hour
+	defaultValuesCacheTimeout     = 30 * time.Second
+
+	defaultWriteProtocol     = writeProtocolGRPC
+	defaultWriteQueueSize    = 10000
+	defaultWriteBatchSize    = 500
+	defaultWriteBatchTimeout = time.Second
+	defaultWriteWorkers      = 4
+	defaultWriteTimeout      = 10 * time.Second
+
+	defaultWALMaxSegmentSize = 16 << 20
+	defaultWALMaxSize        = 1 << 30
+	defaultWALReplayInterval = 10 * time.Second
+
+	defaultDependenciesMaxTraces   = 1000
+	defaultDependenciesConcurrency = 10
+	defaultDependenciesBucketSize  = time.Hour
+	defaultDependenciesRetention   = 7 * 24 * time.Hour
+	defaultDependenciesInterval    = 5 * time.Minute
+
+	defaultArchiveWriteProtocol = writeProtocolGRPC
+	defaultArchiveMaxSize       = 1 << 30
+)
+
 // Config holds the configuration for redbull.
 type Config struct {
 	Backend string `yaml:"backend"`
+	// Transport is how the backend is queried, "http" for Tempo's HTTP API or "grpc" for the
+	// gRPC API of a querier.
+	Transport    string             `yaml:"transport"`
+	Search       SearchConfig       `yaml:"search"`
+	TraceCache   TraceCacheConfig   `yaml:"trace_cache"`
+	ValuesCache  ValuesCacheConfig  `yaml:"values_cache"`
+	Write        WriteConfig        `yaml:"write"`
+	Dependencies DependenciesConfig `yaml:"dependencies"`
+	Archive      ArchiveConfig      `yaml:"archive"`
+	// MetricsAddress is the address to serve Prometheus metrics on, disabled when empty.
+	MetricsAddress string `yaml:"metrics_address"`
+}
+
+// SearchConfig holds the configuration for searching traces.
+type SearchConfig struct {
+	// MaxWindow is the longest time range searched with a single request, longer ranges are
+	// split. It should not exceed the max_duration of Tempo's query frontend, 0 disables splitting.
+	MaxWindow time.Duration `yaml:"max_window"`
+	// TraceHints is the number of traces whose time is remembered from search results, so fetching
+	// them only looks at the blocks of that time. 0 disables the hints.
+	TraceHints int `yaml:"trace_hints"`
+	// Shards is the number of equal windows a search range is split into, each asked for an equal
+	// part of the requested traces, so results are spread over the whole range. The part sparse
+	// windows leave unused is asked for from the others.
+	Shards int `yaml:"shards"`
+	// ShardConcurrency is the number of windows searched at a time.
+	ShardConcurrency int `yaml:"shard_concurrency"`
+	// HydrationWorkers is the number of traces FindTraces fetches from Tempo at a time.
+	HydrationWorkers int `yaml:"hydration_workers"`
+	// SummaryOnly makes FindTraces return a single synthetic root span per trace built from the
+	// search results, instead of fetching every trace.
+	SummaryOnly bool `yaml:"summary_only"`
+	// OperationsTraces is the number of recent traces of a service sampled for the operations and
+	// span kinds of that service. Traces are only sampled to fill the values cache, and only for
+	// Tempo before 2.3, which can't list the operations of a service. Otherwise the operations of
+	// every service are listed, regardless of span kind.
+	OperationsTraces int `yaml:"operations_traces"`
+	// TagRules rewrite the tags of Jaeger searches into Tempo attributes, on top of and overriding
+	// the built-in rules.
+	TagRules []TagRule `yaml:"tag_rules"`
+}
+
+// TagRule rewrites a Jaeger search tag into a Tempo attribute.
+type TagRule struct {
+	// Tag is the tag as typed in Jaeger.
+	Tag string `yaml:"tag" mapstructure:"tag"`
+	// Attribute is the Tempo attribute searched instead, defaults to Tag.
+	Attribute string `yaml:"attribute" mapstructure:"attribute"`
+	// Values maps Jaeger values, regardless of case, to Tempo values. Other values are kept.
+	Values map[string]string `yaml:"values" mapstructure:"values"`
+}
+
+// TraceCacheConfig holds the configuration for caching fetched traces in memory. The cache is
+// disabled unless Size is set.
+type TraceCacheConfig struct {
+	// Size is the number of traces kept, the least recently used are evicted beyond it.
+	Size int `yaml:"size"`
+	// TTL is how long a trace is kept.
+	TTL time.Duration `yaml:"ttl"`
+	// RecentTTL is how long a trace that ended within RecentWindow is kept, as it may still be
+	// receiving spans. 0 doesn't cache recent traces.
+	RecentTTL    time.Duration `yaml:"recent_ttl"`
+	RecentWindow time.Duration `yaml:"recent_window"`
+}
+
+// ValuesCacheConfig holds the configuration for caching the services and operations of every
+// tenant. The cache is disabled unless RefreshInterval is set.
+type ValuesCacheConfig struct {
+	// RefreshInterval is how often cached lists are reloaded from Tempo in the background. The
+	// operations of a service are only reloaded when asked for after the interval.
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+	// IdleTimeout is how long a list is kept and refreshed without being asked for.
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+	// Timeout bounds a single reload of a list.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// WriteConfig holds the configuration for forwarding spans to a Tempo distributor.
+// Span writing is disabled unless Backend is set.
+type WriteConfig struct {
+	// Backend is the host:port of the distributor's OTLP receiver.
+	Backend string `yaml:"backend"`
+	// Protocol is either "grpc" or "http".
+	Protocol string `yaml:"protocol"`
+	// QueueSize is the number of spans held in memory before new spans are rejected.
+	QueueSize int `yaml:"queue_size"`
+	// BatchSize is the number of spans that triggers a flush.
+	BatchSize int `yaml:"batch_size"`
+	// BatchTimeout is the longest a span waits in a batch before it is flushed.
+	BatchTimeout time.Duration `yaml:"batch_timeout"`
+	// Workers is the number of goroutines batching and pushing spans.
+	Workers int `yaml:"workers"`
+	// Timeout bounds a single push to Tempo.
+	Timeout time.Duration `yaml:"timeout"`
+	WAL     WALConfig     `yaml:"wal"`
+}
+
+// WALConfig holds the configuration for the write-ahead log keeping spans that could not be
+// pushed to Tempo. The log is disabled unless Directory is set.
+type WALConfig struct {
+	Directory string `yaml:"directory"`
+	// MaxSegmentSize is the size in bytes at which a new segment file is started.
+	MaxSegmentSize int64 `yaml:"max_segment_size"`
+	// MaxSize is the size in bytes beyond which the oldest segments are dropped.
+	MaxSize int64 `yaml:"max_size"`
+	// ReplayInterval is how often the log is replayed to Tempo.
+	ReplayInterval time.Duration `yaml:"replay_interval"`
+}
+
+// DependenciesConfig holds the configuration for service dependencies. They are read from Tempo's
+// service graph metrics when PrometheusURL is set, and derived from traces otherwise.
+type DependenciesConfig struct {
+	// PrometheusURL is the base URL of a Prometheus compatible query API holding the
+	// traces_service_graph_request_total series, such as http://prometheus:9090.
+	PrometheusURL string `yaml:"prometheus_url"`
+	// MaxTraces is the number of traces sampled from the lookback window.
+	MaxTraces int `yaml:"max_traces"`
+	// Concurrency is the number of traces fetched from Tempo at a time.
+	Concurrency int `yaml:"concurrency"`
+
+	// SnapshotDirectory enables aggregating dependencies in the background, persisting a
+	// snapshot of every bucket in this directory.
+	SnapshotDirectory string `yaml:"snapshot_directory"`
+	// BucketSize is the time range covered by a snapshot.
+	BucketSize time.Duration `yaml:"bucket_size"`
+	// Retention is how long snapshots are kept.
+	Retention time.Duration `yaml:"retention"`
+	// AggregationInterval is how often completed buckets are aggregated.
+	AggregationInterval time.Duration `yaml:"aggregation_interval"`
+}
+
+// ArchiveConfig holds the configuration for the traces archived from the Jaeger UI.
+// Archiving is disabled unless Type is set.
+type ArchiveConfig struct {
+	// Type is "tempo" to archive traces to a long-retention Tempo tenant or endpoint, or
+	// "filesystem" to keep them in a local directory. The tempo archive needs a Tenant, or a
+	// separate Backend and WriteBackend, and write.wal.directory.
+	Type string `yaml:"type"`
+	// Backend is the host:port of the Tempo query API for archived traces, defaults to the main
+	// backend. It must be set together with WriteBackend.
+	Backend string `yaml:"backend"`
+	// Tenant is the tenant archived traces are written to and read from, defaults to the tenant of the request.
+	Tenant string `yaml:"tenant"`
+	// WriteBackend is the host:port of the distributor's OTLP receiver archived spans are pushed
+	// to, defaults to write.backend.
+	WriteBackend string `yaml:"write_backend"`
+	// WriteProtocol is either "grpc" or "http".
+	WriteProtocol string `yaml:"write_protocol"`
+
+	// Directory holds the archived traces of the filesystem archive.
+	Directory string `yaml:"directory"`
+	// Compression is empty or "gzip".
+	Compression string `yaml:"compression"`
+	// MaxSize is the size in bytes beyond which the least recently archived traces are evicted,
+	// 0 disables eviction.
+	MaxSize int64 `yaml:"max_size"`
 }
 
 // InitFromViper initializes the options struct with values from Viper
 func (c *Config) InitFromViper(v *viper.Viper) {
+	v.SetDefault("transport", defaultTransport)
+	v.SetDefault("search.max_window", defaultSearchMaxWindow)
+	v.SetDefault("search.trace_hints", defaultSearchTraceHints)
+	v.SetDefault("search.shards", defaultSearchShards)
+	v.SetDefault("search.shard_concurrency", defaultShardConcurrency)
+	v.SetDefault("search.hydration_workers", defaultSearchWorkers)
+	v.SetDefault("search.operations_traces", defaultOperationsTraces)
+	v.SetDefault("trace_cache.ttl", defaultTraceCacheTTL)
+	v.SetDefault("trace_cache.recent_ttl", defaultTraceCacheRecentTTL)
+	v.SetDefault("trace_cache.recent_window", defaultTraceCacheRecentWindow)
+	v.SetDefault("values_cache.idle_timeout", defaultValuesCacheIdleTimeout)
+	v.SetDefault("values_cache.timeout", defaultValuesCacheTimeout)
+	v.SetDefault("write.protocol", defaultWriteProtocol)
+	v.SetDefault("write.queue_size", defaultWriteQueueSize)
+	v.SetDefault("write.batch_size", defaultWriteBatchSize)
+	v.SetDefault("write.batch_timeout", defaultWriteBatchTimeout)
+	v.SetDefault("write.workers", defaultWriteWorkers)
+	v.SetDefault("write.timeout", defaultWriteTimeout)
+	v.SetDefault("write.wal.max_segment_size", defaultWALMaxSegmentSize)
+	v.SetDefault("write.wal.max_size", defaultWALMaxSize)
+	v.SetDefault("write.wal.replay_interval", defaultWALReplayInterval)
+	v.SetDefault("dependencies.max_traces", defaultDependenciesMaxTraces)
+	v.SetDefault("dependencies.concurrency", defaultDependenciesConcurrency)
+	v.SetDefault("dependencies.bucket_size", defaultDependenciesBucketSize)
+	v.SetDefault("dependencies.retention", defaultDependenciesRetention)
+	v.SetDefault("dependencies.aggregation_interval", defaultDependenciesInterval)
+	v.SetDefault("archive.write_protocol", defaultArchiveWriteProtocol)
+	v.SetDefault("archive.max_size", defaultArchiveMaxSize)
+
 	c.Backend = v.GetString("backend")
+	c.Transport = v.GetString("transport")
+	c.MetricsAddress = v.GetString("metrics_address")
+
+	c.Search.MaxWindow = v.GetDuration("search.max_window")
+	c.Search.TraceHints = v.GetInt("search.trace_hints")
+	c.Search.Shards = v.GetInt("search.shards")
+	c.Search.ShardConcurrency = v.GetInt("search.shard_concurrency")
+	c.Search.HydrationWorkers = v.GetInt("search.hydration_workers")
+	c.Search.SummaryOnly = v.GetBool("search.summary_only")
+	c.Search.OperationsTraces = v.GetInt("search.operations_traces")
+	// a malformed list leaves only the built-in rules, there is no way to report errors from here
+	_ = v.UnmarshalKey("search.tag_rules", &c.Search.TagRules)
+
+	c.TraceCache.Size = v.GetInt("trace_cache.size")
+	c.TraceCache.TTL = v.GetDuration("trace_cache.ttl")
+	c.TraceCache.RecentTTL = v.GetDuration("trace_cache.recent_ttl")
+	c.TraceCache.RecentWindow = v.GetDuration("trace_cache.recent_window")
+
+	c.ValuesCache.RefreshInterval = v.GetDuration("values_cache.refresh_interval")
+	c.ValuesCache.IdleTimeout = v.GetDuration("values_cache.idle_timeout")
+	c.ValuesCache.Timeout = v.GetDuration("values_cache.timeout")
+
+	c.Write.Backend = v.GetString("write.backend")
+	c.Write.Protocol = v.GetString("write.protocol")
+	c.Write.QueueSize = v.GetInt("write.queue_size")
+	c.Write.BatchSize = v.GetInt("write.batch_size")
+	c.Write.BatchTimeout = v.GetDuration("write.batch_timeout")
+	c.Write.Workers = v.GetInt("write.workers")
+	c.Write.Timeout = v.GetDuration("write.timeout")
+	c.Write.WAL.Directory = v.GetString("write.wal.directory")
+	c.Write.WAL.MaxSegmentSize = v.GetInt64("write.wal.max_segment_size")
+	c.Write.WAL.MaxSize = v.GetInt64("write.wal.max_size")
+	c.Write.WAL.ReplayInterval = v.GetDuration("write.wal.replay_interval")
+
+	c.Dependencies.PrometheusURL = v.GetString("dependencies.prometheus_url")
+	c.Dependencies.MaxTraces = v.GetInt("dependencies.max_traces")
+	c.Dependencies.Concurrency = v.GetInt("dependencies.concurrency")
+	c.Dependencies.SnapshotDirectory = v.GetString("dependencies.snapshot_directory")
+	c.Dependencies.BucketSize = v.GetDuration("dependencies.bucket_size")
+	c.Dependencies.Retention = v.GetDuration("dependencies.retention")
+	c.Dependencies.AggregationInterval = v.GetDuration("dependencies.aggregation_interval")
+
+	c.Archive.Type = v.GetString("archive.type")
+	c.Archive.Backend = v.GetString("archive.backend")
+	c.Archive.Tenant = v.GetString("archive.tenant")
+	c.Archive.WriteBackend = v.GetString("archive.write_backend")
+	c.Archive.WriteProtocol = v.GetString("archive.write_protocol")
+	c.Archive.Directory = v.GetString("archive.directory")
+	c.Archive.Compression = v.GetString("archive.compression")
+	c.Archive.MaxSize = v.GetInt64("archive.max_size")
 }
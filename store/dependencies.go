@@ -0,0 +1,139 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/grafana/tempo/pkg/tempopb"
+	"github.com/opentracing/opentracing-go"
+	ot_log "github.com/opentracing/opentracing-go/log"
+
+	jaeger "github.com/jaegertracing/jaeger/model"
+)
+
+const (
+	startSearchTag = "start"
+	endSearchTag   = "end"
+)
+
+type dependencyKey struct {
+	parent string
+	child  string
+}
+
+// traceDependencies derives dependency links from the traces Tempo finds in the lookback window.
+// At most maxTraces traces are sampled, fetching up to concurrency of them at a time.
+type traceDependencies struct {
+	backend     *Backend
+	maxTraces   int
+	concurrency int
+}
+
+func (d *traceDependencies) GetDependencies(ctx context.Context, endTs time.Time, lookback time.Duration) ([]jaeger.DependencyLink, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "tempo-query.GetDependencies")
+	defer span.Finish()
+
+	searchResponse, err := d.backend.search(ctx, &tempopb.SearchRequest{
+		Start: uint32(endTs.Add(-lookback).Unix()),
+		End:   uint32(endTs.Unix()),
+		Limit: uint32(d.maxTraces),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	span.LogFields(ot_log.String("msg", fmt.Sprintf("Sampling %d traces", len(searchResponse.Traces))))
+
+	// parse every ID before fetching, so no fetch outlives a bad one
+	traceIDs := make([]jaeger.TraceID, 0, len(searchResponse.Traces))
+	for _, traceMetadata := range searchResponse.Traces {
+		traceID, err := jaeger.TraceIDFromString(traceMetadata.TraceID)
+		if err != nil {
+			return nil, fmt.Errorf("could not convert traceID into Jaeger's traceID %w", err)
+		}
+		traceIDs = append(traceIDs, traceID)
+	}
+
+	var mtx sync.Mutex
+	counts := map[dependencyKey]uint64{}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, d.concurrency)
+	for _, traceID := range traceIDs {
+		traceID := traceID
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return nil, ctx.Err()
+		}
+
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			trace, err := d.backend.GetTrace(ctx, traceID)
+			if err != nil {
+				// a trace that can't be fetched only makes the sample smaller
+				span.LogFields(ot_log.Error(fmt.Errorf("could not get trace for traceID %v: %w", traceID, err)))
+				return
+			}
+
+			mtx.Lock()
+			defer mtx.Unlock()
+			addTraceDependencies(counts, trace)
+		}()
+	}
+	wg.Wait()
+
+	return dependencyLinks(counts), nil
+}
+
+// addTraceDependencies counts every parent/child span pair of a trace that crosses a service boundary.
+func addTraceDependencies(counts map[dependencyKey]uint64, trace *jaeger.Trace) {
+	spansByID := make(map[jaeger.SpanID]*jaeger.Span, len(trace.Spans))
+	for _, s := range trace.Spans {
+		spansByID[s.SpanID] = s
+	}
+
+	for _, s := range trace.Spans {
+		parent, ok := spansByID[s.ParentSpanID()]
+		if !ok || parent.Process == nil || s.Process == nil {
+			continue
+		}
+
+		if parent.Process.ServiceName == s.Process.ServiceName {
+			continue
+		}
+
+		counts[dependencyKey{parent: parent.Process.ServiceName, child: s.Process.ServiceName}]++
+	}
+}
+
+// dependencyLinks turns call counts into links, sorted so responses are stable.
+func dependencyLinks(counts map[dependencyKey]uint64) []jaeger.DependencyLink {
+	links := make([]jaeger.DependencyLink, 0, len(counts))
+	for key, count := range counts {
+		links = append(links, jaeger.DependencyLink{
+			Parent:    key.parent,
+			Child:     key.child,
+			CallCount: count,
+		})
+	}
+
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].Parent != links[j].Parent {
+			return links[i].Parent < links[j].Parent
+		}
+		return links[i].Child < links[j].Child
+	})
+
+	return links
+}
@@ -0,0 +1,314 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/weaveworks/common/user"
+
+	jaeger "github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/dependencystore"
+)
+
+const (
+	snapshotExtension = ".json"
+)
+
+// dependencyAggregator periodically computes the dependency links of fixed time buckets from
+// source and persists each bucket as a snapshot, so GetDependencies only has to merge the
+// buckets overlapping the requested window and ask source for the parts no bucket covers. Only
+// completed buckets are aggregated, buckets older than the retention are removed.
+//
+// Buckets are kept per tenant. A tenant is aggregated once it has been asked for its dependencies,
+// or when snapshots for it are found on disk, and until then it is answered from source directly.
+type dependencyAggregator struct {
+	source     dependencystore.Reader
+	dir        string
+	bucketSize time.Duration
+	retention  time.Duration
+	logger     hclog.Logger
+
+	mtx sync.RWMutex
+	// buckets holds the links of each tenant keyed by the unix start time of the bucket
+	buckets map[string]map[int64][]jaeger.DependencyLink
+
+	trigger chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func newDependencyAggregator(cfg DependenciesConfig, source dependencystore.Reader, logger hclog.Logger) (*dependencyAggregator, error) {
+	if cfg.BucketSize <= 0 || cfg.AggregationInterval <= 0 {
+		return nil, fmt.Errorf("dependencies.bucket_size and dependencies.aggregation_interval must be positive")
+	}
+	if err := os.MkdirAll(cfg.SnapshotDirectory, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create dependencies snapshot directory: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	a := &dependencyAggregator{
+		source:     source,
+		dir:        cfg.SnapshotDirectory,
+		bucketSize: cfg.BucketSize,
+		retention:  cfg.Retention,
+		logger:     logger,
+		buckets:    map[string]map[int64][]jaeger.DependencyLink{},
+		trigger:    make(chan struct{}, 1),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+
+	if err := a.loadSnapshots(); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	a.wg.Add(1)
+	go a.run(cfg.AggregationInterval)
+
+	return a, nil
+}
+
+// dependencyRange is a part of a dependencies window that no bucket covers.
+type dependencyRange struct {
+	start time.Time
+	end   time.Time
+}
+
+func (a *dependencyAggregator) GetDependencies(ctx context.Context, endTs time.Time, lookback time.Duration) ([]jaeger.DependencyLink, error) {
+	tenantID, _ := extractTenantID(ctx)
+
+	a.mtx.Lock()
+	buckets, ok := a.buckets[tenantID]
+	if !ok {
+		buckets = map[int64][]jaeger.DependencyLink{}
+		a.buckets[tenantID] = buckets
+	}
+	empty := len(buckets) == 0
+	a.mtx.Unlock()
+
+	if empty {
+		a.triggerAggregation()
+	}
+
+	windowStart := endTs.Add(-lookback)
+	counts := map[dependencyKey]uint64{}
+
+	// the current bucket is never complete and older ones may not have been aggregated yet, or
+	// failed to, so the parts of the window without a bucket are asked from source
+	var uncovered []dependencyRange
+	a.mtx.RLock()
+	for start := windowStart.Truncate(a.bucketSize); start.Before(endTs); start = start.Add(a.bucketSize) {
+		if links, ok := buckets[start.Unix()]; ok {
+			for _, link := range links {
+				counts[dependencyKey{parent: link.Parent, child: link.Child}] += link.CallCount
+			}
+			continue
+		}
+
+		gap := dependencyRange{start: start, end: start.Add(a.bucketSize)}
+		if gap.start.Before(windowStart) {
+			gap.start = windowStart
+		}
+		if gap.end.After(endTs) {
+			gap.end = endTs
+		}
+		if n := len(uncovered); n > 0 && uncovered[n-1].end.Equal(gap.start) {
+			uncovered[n-1].end = gap.end
+			continue
+		}
+		uncovered = append(uncovered, gap)
+	}
+	a.mtx.RUnlock()
+
+	for _, gap := range uncovered {
+		links, err := a.source.GetDependencies(ctx, gap.end, gap.end.Sub(gap.start))
+		if err != nil {
+			return nil, err
+		}
+		for _, link := range links {
+			counts[dependencyKey{parent: link.Parent, child: link.Child}] += link.CallCount
+		}
+	}
+
+	return dependencyLinks(counts), nil
+}
+
+func (a *dependencyAggregator) triggerAggregation() {
+	select {
+	case a.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (a *dependencyAggregator) run(interval time.Duration) {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		a.aggregate(time.Now())
+
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-a.trigger:
+		}
+	}
+}
+
+// aggregate computes the missing completed buckets of every tenant within the retention, newest
+// first, and removes the buckets that fell out of it.
+func (a *dependencyAggregator) aggregate(now time.Time) {
+	lastStart := now.Truncate(a.bucketSize).Add(-a.bucketSize)
+	oldestStart := now.Add(-a.retention).Truncate(a.bucketSize)
+
+	a.mtx.RLock()
+	tenants := make([]string, 0, len(a.buckets))
+	for tenantID := range a.buckets {
+		tenants = append(tenants, tenantID)
+	}
+	a.mtx.RUnlock()
+
+	for _, tenantID := range tenants {
+		a.expire(tenantID, oldestStart)
+
+		for start := lastStart; !start.Before(oldestStart); start = start.Add(-a.bucketSize) {
+			if a.ctx.Err() != nil {
+				return
+			}
+
+			a.mtx.RLock()
+			_, done := a.buckets[tenantID][start.Unix()]
+			a.mtx.RUnlock()
+			if done {
+				continue
+			}
+
+			ctx := a.ctx
+			if tenantID != "" {
+				ctx = user.InjectOrgID(ctx, tenantID)
+			}
+
+			links, err := a.source.GetDependencies(ctx, start.Add(a.bucketSize), a.bucketSize)
+			if err != nil {
+				// leave the bucket missing so it is retried on the next run
+				a.logger.Error("failed to aggregate dependencies", "tenant", tenantID, "bucket", start, "error", err)
+				break
+			}
+
+			if err := a.writeSnapshot(tenantID, start.Unix(), links); err != nil {
+				a.logger.Error("failed to persist dependencies snapshot", "tenant", tenantID, "bucket", start, "error", err)
+			}
+
+			a.mtx.Lock()
+			a.buckets[tenantID][start.Unix()] = links
+			a.mtx.Unlock()
+		}
+	}
+}
+
+// expire removes the buckets of a tenant that start before oldestStart.
+func (a *dependencyAggregator) expire(tenantID string, oldestStart time.Time) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	for start := range a.buckets[tenantID] {
+		if start >= oldestStart.Unix() {
+			continue
+		}
+
+		delete(a.buckets[tenantID], start)
+		if err := os.Remove(a.snapshotPath(tenantID, start)); err != nil && !os.IsNotExist(err) {
+			a.logger.Error("failed to remove expired dependencies snapshot", "tenant", tenantID, "error", err)
+		}
+	}
+}
+
+func (a *dependencyAggregator) snapshotPath(tenantID string, start int64) string {
+	return filepath.Join(tenantDirectory(a.dir, tenantID), strconv.FormatInt(start, 10)+snapshotExtension)
+}
+
+// writeSnapshot persists the links of a bucket, replacing the file atomically.
+func (a *dependencyAggregator) writeSnapshot(tenantID string, start int64, links []jaeger.DependencyLink) error {
+	if err := os.MkdirAll(tenantDirectory(a.dir, tenantID), 0o700); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(links)
+	if err != nil {
+		return err
+	}
+
+	path := a.snapshotPath(tenantID, start)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadSnapshots reads the buckets persisted by previous runs.
+func (a *dependencyAggregator) loadSnapshots() error {
+	tenantDirs, err := os.ReadDir(a.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read dependencies snapshot directory: %w", err)
+	}
+
+	for _, tenantDir := range tenantDirs {
+		if !tenantDir.IsDir() {
+			continue
+		}
+
+		tenantID, ok := tenantFromDirectory(tenantDir.Name())
+		if !ok {
+			a.logger.Warn("ignoring unexpected directory in dependencies snapshot directory", "directory", tenantDir.Name())
+			continue
+		}
+
+		snapshots, err := os.ReadDir(filepath.Join(a.dir, tenantDir.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read dependencies snapshot directory: %w", err)
+		}
+
+		buckets := map[int64][]jaeger.DependencyLink{}
+		for _, snapshot := range snapshots {
+			start, err := strconv.ParseInt(strings.TrimSuffix(snapshot.Name(), snapshotExtension), 10, 64)
+			if err != nil || !strings.HasSuffix(snapshot.Name(), snapshotExtension) {
+				continue
+			}
+
+			data, err := os.ReadFile(filepath.Join(a.dir, tenantDir.Name(), snapshot.Name()))
+			if err != nil {
+				return fmt.Errorf("failed to read dependencies snapshot: %w", err)
+			}
+
+			var links []jaeger.DependencyLink
+			if err := json.Unmarshal(data, &links); err != nil {
+				// the bucket is aggregated again on the next run
+				a.logger.Warn("ignoring corrupt dependencies snapshot", "snapshot", snapshot.Name(), "error", err)
+				continue
+			}
+			buckets[start] = links
+		}
+		a.buckets[tenantID] = buckets
+	}
+
+	return nil
+}
+
+func (a *dependencyAggregator) close() {
+	a.cancel()
+	a.wg.Wait()
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+
+	jaeger "github.com/jaegertracing/jaeger/model"
+)
+
+// recordingDependencies returns one link per call and records the windows it was asked for.
+type recordingDependencies struct {
+	calls []dependencyRange
+}
+
+func (d *recordingDependencies) GetDependencies(_ context.Context, endTs time.Time, lookback time.Duration) ([]jaeger.DependencyLink, error) {
+	d.calls = append(d.calls, dependencyRange{start: endTs.Add(-lookback), end: endTs})
+	return []jaeger.DependencyLink{{Parent: "source", Child: "db", CallCount: 1}}, nil
+}
+
+func TestDependencyAggregatorAsksSourceForUncoveredRanges(t *testing.T) {
+	bucketSize := time.Hour
+	base := time.Unix(1650000000, 0).Truncate(bucketSize)
+	bucket := func(i int) time.Time { return base.Add(time.Duration(i) * bucketSize) }
+
+	source := &recordingDependencies{}
+	a := &dependencyAggregator{
+		source:     source,
+		bucketSize: bucketSize,
+		logger:     hclog.NewNullLogger(),
+		buckets: map[string]map[int64][]jaeger.DependencyLink{
+			// bucket 2 is missing as if its aggregation failed, bucket 4 is still in progress
+			"": {
+				bucket(0).Unix(): {{Parent: "frontend", Child: "orders", CallCount: 10}},
+				bucket(1).Unix(): {{Parent: "frontend", Child: "orders", CallCount: 20}},
+				bucket(3).Unix(): {{Parent: "frontend", Child: "orders", CallCount: 30}},
+			},
+		},
+		trigger: make(chan struct{}, 1),
+	}
+
+	// from halfway through the hour before bucket 0 until halfway through bucket 4
+	windowStart := bucket(-1).Add(bucketSize / 2)
+	endTs := bucket(4).Add(bucketSize / 2)
+
+	links, err := a.GetDependencies(context.Background(), endTs, endTs.Sub(windowStart))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedCalls := []dependencyRange{
+		{start: windowStart, end: bucket(0)},
+		{start: bucket(2), end: bucket(3)},
+		{start: bucket(4), end: endTs},
+	}
+	if !reflect.DeepEqual(source.calls, expectedCalls) {
+		t.Errorf("expected source to be asked for %v, got %v", expectedCalls, source.calls)
+	}
+
+	expectedLinks := []jaeger.DependencyLink{
+		{Parent: "frontend", Child: "orders", CallCount: 60},
+		{Parent: "source", Child: "db", CallCount: 3},
+	}
+	if !reflect.DeepEqual(links, expectedLinks) {
+		t.Errorf("expected links %v, got %v", expectedLinks, links)
+	}
+}
+
+func TestDependencyAggregatorAsksSourceForNewTenant(t *testing.T) {
+	source := &recordingDependencies{}
+	a := &dependencyAggregator{
+		source:     source,
+		bucketSize: time.Hour,
+		logger:     hclog.NewNullLogger(),
+		buckets:    map[string]map[int64][]jaeger.DependencyLink{},
+		trigger:    make(chan struct{}, 1),
+	}
+
+	endTs := time.Unix(1650000000, 0)
+	if _, err := a.GetDependencies(context.Background(), endTs, 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedCalls := []dependencyRange{{start: endTs.Add(-24 * time.Hour), end: endTs}}
+	if !reflect.DeepEqual(source.calls, expectedCalls) {
+		t.Errorf("expected source to be asked for %v, got %v", expectedCalls, source.calls)
+	}
+	select {
+	case <-a.trigger:
+	default:
+		t.Error("expected aggregation of the new tenant to be triggered")
+	}
+}
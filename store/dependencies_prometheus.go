@@ -0,0 +1,114 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+
+	jaeger "github.com/jaegertracing/jaeger/model"
+)
+
+const (
+	serviceGraphRequestsMetric = "traces_service_graph_request_total"
+	serviceGraphClientLabel    = "client"
+	serviceGraphServerLabel    = "server"
+)
+
+// prometheusDependencies reads dependency links from the service graph metrics Tempo's
+// metrics-generator writes to a Prometheus compatible store.
+type prometheusDependencies struct {
+	// url is the base of the Prometheus HTTP API, such as http://prometheus:9090
+	url string
+}
+
+type prometheusQueryResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+	Data      struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Metric map[string]string `json:"metric"`
+			// Value holds the sample timestamp and the sample value as a string
+			Value [2]interface{} `json:"value"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+func (d *prometheusDependencies) GetDependencies(ctx context.Context, endTs time.Time, lookback time.Duration) ([]jaeger.DependencyLink, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "tempo-query.GetDependencies")
+	defer span.Finish()
+
+	query := fmt.Sprintf("sum by (%s, %s) (increase(%s[%ds]))",
+		serviceGraphClientLabel, serviceGraphServerLabel, serviceGraphRequestsMetric, int64(lookback.Seconds()))
+
+	urlQuery := url.Values{}
+	urlQuery.Set("query", query)
+	urlQuery.Set("time", strconv.FormatInt(endTs.Unix(), 10))
+
+	req, err := newRequest(ctx, "GET", strings.TrimSuffix(d.url, "/")+"/api/v1/query?"+urlQuery.Encode(), nil, span)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed GET to prometheus %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response from prometheus: %w", err)
+	}
+
+	var queryResponse prometheusQueryResponse
+	if err := json.Unmarshal(body, &queryResponse); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s", body)
+		}
+		return nil, fmt.Errorf("error unmarshaling prometheus response: %w", err)
+	}
+
+	if queryResponse.Status != "success" {
+		return nil, fmt.Errorf("prometheus query failed: %s: %s", queryResponse.ErrorType, queryResponse.Error)
+	}
+	if queryResponse.Data.ResultType != "vector" {
+		return nil, fmt.Errorf("unexpected prometheus result type %q", queryResponse.Data.ResultType)
+	}
+
+	counts := map[dependencyKey]uint64{}
+	for _, sample := range queryResponse.Data.Result {
+		parent := sample.Metric[serviceGraphClientLabel]
+		child := sample.Metric[serviceGraphServerLabel]
+		if parent == "" || child == "" {
+			continue
+		}
+
+		value, ok := sample.Value[1].(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected prometheus sample value %v", sample.Value[1])
+		}
+		count, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing prometheus sample value: %w", err)
+		}
+		// increase() extrapolates, so counts are rarely whole numbers
+		if count = math.Round(count); count < 1 || math.IsNaN(count) || math.IsInf(count, 0) {
+			continue
+		}
+
+		counts[dependencyKey{parent: parent, child: child}] += uint64(count)
+	}
+
+	return dependencyLinks(counts), nil
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	jaeger "github.com/jaegertracing/jaeger/model"
+)
+
+func TestPrometheusDependencies(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		expected []jaeger.DependencyLink
+		err      bool
+	}{
+		{
+			name:   "vector",
+			status: http.StatusOK,
+			body: `{"status":"success","data":{"resultType":"vector","result":[
+				{"metric":{"client":"frontend","server":"orders"},"value":[1650000000,"12"]},
+				{"metric":{"client":"frontend","server":"cart"},"value":[1650000000,"3"]},
+				{"metric":{"client":"orders","server":"db"},"value":[1650000000,"7"]}
+			]}}`,
+			expected: []jaeger.DependencyLink{
+				{Parent: "frontend", Child: "cart", CallCount: 3},
+				{Parent: "frontend", Child: "orders", CallCount: 12},
+				{Parent: "orders", Child: "db", CallCount: 7},
+			},
+		},
+		{
+			name:   "fractional increase",
+			status: http.StatusOK,
+			body: `{"status":"success","data":{"resultType":"vector","result":[
+				{"metric":{"client":"frontend","server":"orders"},"value":[1650000000,"11.6"]},
+				{"metric":{"client":"frontend","server":"cart"},"value":[1650000000,"2.4"]},
+				{"metric":{"client":"orders","server":"db"},"value":[1650000000,"0.4"]},
+				{"metric":{"client":"orders","server":"cache"},"value":[1650000000,"NaN"]}
+			]}}`,
+			expected: []jaeger.DependencyLink{
+				{Parent: "frontend", Child: "cart", CallCount: 2},
+				{Parent: "frontend", Child: "orders", CallCount: 12},
+			},
+		},
+		{
+			name:   "missing labels",
+			status: http.StatusOK,
+			body: `{"status":"success","data":{"resultType":"vector","result":[
+				{"metric":{"server":"orders"},"value":[1650000000,"5"]},
+				{"metric":{"client":"frontend"},"value":[1650000000,"5"]},
+				{"metric":{"client":"frontend","server":"orders"},"value":[1650000000,"1"]}
+			]}}`,
+			expected: []jaeger.DependencyLink{
+				{Parent: "frontend", Child: "orders", CallCount: 1},
+			},
+		},
+		{
+			name:     "empty vector",
+			status:   http.StatusOK,
+			body:     `{"status":"success","data":{"resultType":"vector","result":[]}}`,
+			expected: []jaeger.DependencyLink{},
+		},
+		{
+			name:   "status error",
+			status: http.StatusBadRequest,
+			body:   `{"status":"error","errorType":"bad_data","error":"invalid parameter \"query\""}`,
+			err:    true,
+		},
+		{
+			name:   "matrix result",
+			status: http.StatusOK,
+			body:   `{"status":"success","data":{"resultType":"matrix","result":[]}}`,
+			err:    true,
+		},
+		{
+			name:   "not json",
+			status: http.StatusBadGateway,
+			body:   `bad gateway`,
+			err:    true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var query, queryTime string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/api/v1/query" {
+					http.NotFound(w, r)
+					return
+				}
+				query = r.URL.Query().Get("query")
+				queryTime = r.URL.Query().Get("time")
+				w.WriteHeader(tc.status)
+				_, _ = w.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+
+			d := &prometheusDependencies{url: server.URL + "/"}
+			links, err := d.GetDependencies(context.Background(), time.Unix(1650000000, 0), time.Hour)
+			if (err != nil) != tc.err {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+			if !reflect.DeepEqual(links, tc.expected) {
+				t.Errorf("expected links %v, got %v", tc.expected, links)
+			}
+
+			if expected := "sum by (client, server) (increase(traces_service_graph_request_total[3600s]))"; query != expected {
+				t.Errorf("expected query %q, got %q", expected, query)
+			}
+			if queryTime != "1650000000" {
+				t.Errorf("expected query time 1650000000, got %q", queryTime)
+			}
+		})
+	}
+}
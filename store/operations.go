@@ -0,0 +1,133 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/tempo/pkg/tempopb"
+	"github.com/opentracing/opentracing-go"
+	ot_log "github.com/opentracing/opentracing-go/log"
+
+	jaeger "github.com/jaegertracing/jaeger/model"
+	jaeger_spanstore "github.com/jaegertracing/jaeger/storage/spanstore"
+)
+
+const (
+	spanKindTag = "span.kind"
+
+	// the TraceQL tags of span names, service names and span kinds
+	traceQLNameTag    = "name"
+	traceQLServiceTag = "resource.service.name"
+	traceQLKindTag    = "kind"
+)
+
+// traceQLSpanKinds are the span kinds TraceQL knows, which are named as in Jaeger.
+var traceQLSpanKinds = map[string]struct{}{
+	"unspecified": {},
+	"internal":    {},
+	"server":      {},
+	"client":      {},
+	"producer":    {},
+	"consumer":    {},
+}
+
+// scopedOperations lists the span names matching the service and span kind of a query with a
+// single tag values request, if Tempo can filter tag values by a TraceQL query. It returns false
+// if it can't.
+func (b *Backend) scopedOperations(ctx context.Context, query jaeger_spanstore.OperationQueryParameters) ([]jaeger_spanstore.Operation, bool, error) {
+	var conditions []string
+	if query.ServiceName != "" {
+		conditions = append(conditions, fmt.Sprintf("%s=%s", traceQLServiceTag, strconv.Quote(query.ServiceName)))
+	}
+	if query.SpanKind != "" {
+		if _, ok := traceQLSpanKinds[query.SpanKind]; !ok {
+			return nil, true, nil
+		}
+		conditions = append(conditions, fmt.Sprintf("%s=%s", traceQLKindTag, query.SpanKind))
+	}
+	traceQL := "{" + strings.Join(conditions, " && ") + "}"
+
+	result, err := b.coalescer.do(ctx, "scoped-tag-values/"+traceQLNameTag+"/"+traceQL, func(ctx context.Context) (interface{}, error) {
+		values, ok, err := b.querier.searchScopedTagValues(ctx, traceQLNameTag, traceQL)
+		if err != nil || !ok {
+			return nil, err
+		}
+		return values, nil
+	})
+	if err != nil || result == nil {
+		return nil, false, err
+	}
+
+	values := result.([]string)
+	operations := make([]jaeger_spanstore.Operation, 0, len(values))
+	for _, value := range values {
+		operations = append(operations, jaeger_spanstore.Operation{Name: value, SpanKind: query.SpanKind})
+	}
+	sort.Slice(operations, func(i, j int) bool { return operations[i].Name < operations[j].Name })
+	return operations, true, nil
+}
+
+// serviceOperations lists the operations of a service with their span kinds. Tempo before 2.3 can't
+// list the span names of one service, so they are collected from a sample of the service's recent
+// traces.
+func (b *Backend) serviceOperations(ctx context.Context, span opentracing.Span, query jaeger_spanstore.OperationQueryParameters) ([]jaeger_spanstore.Operation, error) {
+	searchResponse, err := b.search(ctx, &tempopb.SearchRequest{
+		Tags:  map[string]string{serviceSearchTag: query.ServiceName},
+		Limit: uint32(b.operationsTraces),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	traceIDs := make([]jaeger.TraceID, 0, len(searchResponse.Traces))
+	for _, traceMetadata := range searchResponse.Traces {
+		traceID, err := jaeger.TraceIDFromString(traceMetadata.TraceID)
+		if err != nil {
+			return nil, fmt.Errorf("could not convert traceID into Jaeger's traceID %w", err)
+		}
+		traceIDs = append(traceIDs, traceID)
+	}
+
+	span.LogFields(ot_log.String("msg", fmt.Sprintf("Sampling %d traces", len(traceIDs))))
+
+	traces, err := b.hydrate(ctx, span, traceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[jaeger_spanstore.Operation]struct{}{}
+	var operations []jaeger_spanstore.Operation
+	for _, trace := range traces {
+		for _, s := range trace.Spans {
+			if s.Process == nil || s.Process.ServiceName != query.ServiceName {
+				continue
+			}
+
+			operation := jaeger_spanstore.Operation{Name: s.OperationName}
+			if kind, ok := jaeger.KeyValues(s.Tags).FindByKey(spanKindTag); ok {
+				operation.SpanKind = kind.AsString()
+			}
+			if query.SpanKind != "" && operation.SpanKind != query.SpanKind {
+				continue
+			}
+
+			if _, ok := seen[operation]; ok {
+				continue
+			}
+			seen[operation] = struct{}{}
+			operations = append(operations, operation)
+		}
+	}
+
+	sort.Slice(operations, func(i, j int) bool {
+		if operations[i].Name != operations[j].Name {
+			return operations[i].Name < operations[j].Name
+		}
+		return operations[i].SpanKind < operations[j].SpanKind
+	})
+
+	return operations, nil
+}
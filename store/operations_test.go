@@ -0,0 +1,88 @@
+package store
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	jaeger_spanstore "github.com/jaegertracing/jaeger/storage/spanstore"
+)
+
+func TestLoadOperations(t *testing.T) {
+	tests := []struct {
+		name     string
+		version  *tempoVersion
+		query    jaeger_spanstore.OperationQueryParameters
+		expected []jaeger_spanstore.Operation
+		// requests is the path and TraceQL query of every request made to Tempo
+		requests []string
+	}{
+		{
+			name:     "scoped by service and kind",
+			version:  &tempoVersion{major: 2, minor: 3},
+			query:    jaeger_spanstore.OperationQueryParameters{ServiceName: "frontend", SpanKind: "server"},
+			expected: []jaeger_spanstore.Operation{{Name: "GET /", SpanKind: "server"}, {Name: "POST /orders", SpanKind: "server"}},
+			requests: []string{`/api/v2/search/tag/name/values {resource.service.name="frontend" && kind=server}`},
+		},
+		{
+			name:     "scoped by kind",
+			version:  &tempoVersion{major: 2, minor: 4},
+			query:    jaeger_spanstore.OperationQueryParameters{SpanKind: "client"},
+			expected: []jaeger_spanstore.Operation{{Name: "GET /", SpanKind: "client"}, {Name: "POST /orders", SpanKind: "client"}},
+			requests: []string{`/api/v2/search/tag/name/values {kind=client}`},
+		},
+		{
+			name:     "unknown kind",
+			version:  &tempoVersion{major: 2, minor: 3},
+			query:    jaeger_spanstore.OperationQueryParameters{ServiceName: "frontend", SpanKind: "bogus"},
+			expected: nil,
+		},
+		{
+			// without the values cache no traces are sampled
+			name:     "tempo before 2.3",
+			version:  &tempoVersion{major: 2, minor: 2},
+			query:    jaeger_spanstore.OperationQueryParameters{ServiceName: "frontend", SpanKind: "server"},
+			expected: []jaeger_spanstore.Operation{{Name: "SELECT"}},
+			requests: []string{"/api/search/tag/name/values "},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var requests []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests = append(requests, r.URL.Path+" "+r.URL.Query().Get(traceQLQueryParam))
+				switch r.URL.Path {
+				case "/api/v2/search/tag/name/values":
+					_, _ = w.Write([]byte(`{"tagValues":[{"type":"string","value":"POST /orders"},{"type":"string","value":"GET /"}]}`))
+				case "/api/search/tag/name/values":
+					_, _ = w.Write([]byte(`{"tagValues":["SELECT"]}`))
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer server.Close()
+
+			b := &Backend{
+				querier:   &httpQuerier{tempoBackend: strings.TrimPrefix(server.URL, "http://"), api: tempoAPIV1, version: tc.version},
+				coalescer: newCoalescer(),
+			}
+			operations, err := b.loadOperations(context.Background(), tc.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(operations) != 0 || len(tc.expected) != 0 {
+				if !reflect.DeepEqual(operations, tc.expected) {
+					t.Errorf("expected operations %v, got %v", tc.expected, operations)
+				}
+			}
+			if !reflect.DeepEqual(requests, tc.requests) {
+				t.Errorf("expected requests %q, got %q", tc.requests, requests)
+			}
+		})
+	}
+}
@@ -5,12 +5,11 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
-	"strconv"
+	"sync"
 	"time"
 
-	"github.com/gogo/protobuf/jsonpb"
 	"github.com/grafana/tempo/pkg/tempopb"
+	"github.com/hashicorp/go-hclog"
 	"github.com/opentracing/opentracing-go"
 	ot_log "github.com/opentracing/opentracing-go/log"
 	"github.com/weaveworks/common/user"
@@ -18,10 +17,11 @@ import (
 
 	jaeger "github.com/jaegertracing/jaeger/model"
 	"github.com/jaegertracing/jaeger/plugin/storage/grpc/shared"
+	"github.com/jaegertracing/jaeger/storage/dependencystore"
 	jaeger_spanstore "github.com/jaegertracing/jaeger/storage/spanstore"
 
 	ot_jaeger "github.com/open-telemetry/opentelemetry-collector-contrib/pkg/translator/jaeger"
-	"go.opentelemetry.io/collector/model/otlp"
+	"go.opentelemetry.io/collector/model/pdata"
 )
 
 const (
@@ -35,58 +35,155 @@ const (
 	minDurationSearchTag = "minDuration"
 	maxDurationSearchTag = "maxDuration"
 	numTracesSearchTag   = "limit"
+	// traceQLSearchTag is the reserved tag a TraceQL query is typed into in the Jaeger UI
+	traceQLSearchTag  = "traceql"
+	traceQLQueryParam = "q"
 )
 
 type Backend struct {
-	tempoBackend string
+	querier querier
+	// maxSearchWindow is the longest range in seconds searched with a single request
+	maxSearchWindow uint32
+	// searchShards is the number of windows a search range is split into, searched
+	// shardConcurrency at a time
+	searchShards     int
+	shardConcurrency int
+	traceHints       *traceHints
+	// hydrationWorkers is the number of traces FindTraces fetches at a time
+	hydrationWorkers int
+	// summaryOnly makes FindTraces return the summaries of the search instead of full traces
+	summaryOnly bool
+	// operationsTraces is the number of traces of a service sampled for its operations
+	operationsTraces int
+	tagTranslator    tagTranslator
+	traceCache       *traceCache
+	valuesCache      *valuesCache
+	coalescer        *coalescer
+	writer           *batchWriter
+	dependencies     dependencystore.Reader
+	aggregator       *dependencyAggregator
 }
 
-func New(cfg *Config) *Backend {
-	return &Backend{
-		tempoBackend: cfg.Backend,
+func New(cfg *Config, logger hclog.Logger) (*Backend, error) {
+	querier, err := newQuerier(cfg, logger)
+	if err != nil {
+		return nil, err
 	}
-}
 
-func (b *Backend) GetDependencies(ctx context.Context, endTs time.Time, lookback time.Duration) ([]jaeger.DependencyLink, error) {
-	return nil, nil
-}
+	if cfg.Search.MaxWindow < 0 || cfg.Search.TraceHints < 0 {
+		return nil, fmt.Errorf("search.max_window and search.trace_hints must not be negative")
+	}
+	if cfg.Search.HydrationWorkers < 1 || cfg.Search.OperationsTraces < 1 {
+		return nil, fmt.Errorf("search.hydration_workers and search.operations_traces must be at least 1")
+	}
+	if cfg.Search.Shards < 1 || cfg.Search.ShardConcurrency < 1 {
+		return nil, fmt.Errorf("search.shards and search.shard_concurrency must be at least 1")
+	}
+	if cfg.TraceCache.Size < 0 {
+		return nil, fmt.Errorf("trace_cache.size must not be negative")
+	}
+	for _, rule := range cfg.Search.TagRules {
+		if rule.Tag == "" {
+			return nil, fmt.Errorf("search.tag_rules must set a tag")
+		}
+	}
 
-func (b *Backend) GetTrace(ctx context.Context, traceID jaeger.TraceID) (*jaeger.Trace, error) {
-	url := fmt.Sprintf("http://%s/api/traces/%s", b.tempoBackend, traceID)
+	b := &Backend{
+		querier:          querier,
+		maxSearchWindow:  uint32(cfg.Search.MaxWindow.Seconds()),
+		searchShards:     cfg.Search.Shards,
+		shardConcurrency: cfg.Search.ShardConcurrency,
+		hydrationWorkers: cfg.Search.HydrationWorkers,
+		summaryOnly:      cfg.Search.SummaryOnly,
+		operationsTraces: cfg.Search.OperationsTraces,
+		tagTranslator:    newTagTranslator(cfg.Search.TagRules),
+		coalescer:        newCoalescer(),
+	}
+	if cfg.Search.TraceHints > 0 {
+		b.traceHints = newTraceHints(cfg.Search.TraceHints)
+	}
+	if cfg.TraceCache.Size > 0 {
+		b.traceCache = newTraceCache(cfg.TraceCache)
+	}
+	if cfg.ValuesCache.RefreshInterval > 0 {
+		if cfg.ValuesCache.Timeout <= 0 {
+			return nil, fmt.Errorf("values_cache.timeout must be positive")
+		}
+		b.valuesCache = newValuesCache(cfg.ValuesCache, logger)
+	}
 
-	span, ctx := opentracing.StartSpanFromContext(ctx, "tempo-query.GetTrace")
-	defer span.Finish()
+	if cfg.Dependencies.PrometheusURL != "" {
+		b.dependencies = &prometheusDependencies{
+			url: cfg.Dependencies.PrometheusURL,
+		}
+	} else {
+		if cfg.Dependencies.MaxTraces < 1 || cfg.Dependencies.Concurrency < 1 {
+			return nil, fmt.Errorf("dependencies.max_traces and dependencies.concurrency must be at least 1")
+		}
+		b.dependencies = &traceDependencies{
+			backend:     b,
+			maxTraces:   cfg.Dependencies.MaxTraces,
+			concurrency: cfg.Dependencies.Concurrency,
+		}
+	}
 
-	req, err := b.newGetRequest(ctx, url, span)
-	if err != nil {
-		return nil, err
+	if cfg.Dependencies.SnapshotDirectory != "" {
+		aggregator, err := newDependencyAggregator(cfg.Dependencies, b.dependencies, logger)
+		if err != nil {
+			return nil, err
+		}
+		b.aggregator = aggregator
+		b.dependencies = aggregator
 	}
 
-	// Set content type to GRPC
-	req.Header.Set(AcceptHeaderKey, ProtobufTypeHeaderValue)
+	if cfg.Write.Backend != "" {
+		if cfg.Write.Workers < 1 || cfg.Write.BatchSize < 1 {
+			return nil, fmt.Errorf("write.workers and write.batch_size must be at least 1")
+		}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed GET to tempo %w", err)
+		exporter, err := newSpanExporter(cfg.Write)
+		if err != nil {
+			return nil, err
+		}
+		writer, err := newBatchWriter(cfg.Write, exporter, logger)
+		if err != nil {
+			return nil, err
+		}
+		b.writer = writer
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusNotFound {
-		return nil, jaeger_spanstore.ErrTraceNotFound
-	}
+	return b, nil
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error reading response from tempo: %w", err)
+func (b *Backend) GetDependencies(ctx context.Context, endTs time.Time, lookback time.Duration) ([]jaeger.DependencyLink, error) {
+	return b.dependencies.GetDependencies(ctx, endTs, lookback)
+}
+
+func (b *Backend) GetTrace(ctx context.Context, traceID jaeger.TraceID) (*jaeger.Trace, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "tempo-query.GetTrace")
+	defer span.Finish()
+
+	if b.traceCache != nil {
+		if trace, ok := b.traceCache.get(ctx, traceID); ok {
+			span.LogFields(ot_log.String("msg", "trace cache hit"))
+			return trace, nil
+		}
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("%s", body)
+	trace, err := b.coalescer.do(ctx, "trace/"+traceID.String(), func(ctx context.Context) (interface{}, error) {
+		return b.fetchTrace(ctx, span, traceID)
+	})
+	if err != nil {
+		return nil, err
 	}
+	return trace.(*jaeger.Trace), nil
+}
 
-	otTrace, err := otlp.NewProtobufTracesUnmarshaler().UnmarshalTraces(body)
+// fetchTrace fetches a trace from Tempo and converts it to a Jaeger trace.
+func (b *Backend) fetchTrace(ctx context.Context, span opentracing.Span, traceID jaeger.TraceID) (*jaeger.Trace, error) {
+	otTrace, err := b.findTraceByID(ctx, span, traceID)
 	if err != nil {
-		return nil, fmt.Errorf("error unmarshalling body to otlp trace %v: %w", traceID, err)
+		return nil, err
 	}
 
 	jaegerBatches, err := ot_jaeger.ProtoFromTraces(otTrace)
@@ -100,17 +197,31 @@ func (b *Backend) GetTrace(ctx context.Context, traceID jaeger.TraceID) (*jaeger
 	}
 
 	span.LogFields(ot_log.String("msg", "build process map"))
-	// otel proto conversion doesn't set jaeger processes
+	// otel proto conversion doesn't set jaeger processes. replicas of a service share its name, so
+	// every distinct set of process tags gets its own ID
+	processIDs := map[string]string{}
 	for _, batch := range jaegerBatches {
+		key := processKey(batch.Process)
+		processID, ok := processIDs[key]
+		if !ok {
+			processID = fmt.Sprintf("p%d", len(processIDs)+1)
+			processIDs[key] = processID
+			jaegerTrace.ProcessMap = append(jaegerTrace.ProcessMap, jaeger.Trace_ProcessMapping{
+				Process:   *batch.Process,
+				ProcessID: processID,
+			})
+		}
+
 		for _, s := range batch.Spans {
 			s.Process = batch.Process
+			s.ProcessID = processID
 		}
 
 		jaegerTrace.Spans = append(jaegerTrace.Spans, batch.Spans...)
-		jaegerTrace.ProcessMap = append(jaegerTrace.ProcessMap, jaeger.Trace_ProcessMapping{
-			Process:   *batch.Process,
-			ProcessID: batch.Process.ServiceName,
-		})
+	}
+
+	if b.traceCache != nil {
+		b.traceCache.add(ctx, traceID, jaegerTrace)
 	}
 
 	return jaegerTrace, nil
@@ -120,19 +231,71 @@ func (b *Backend) GetServices(ctx context.Context) ([]string, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "tempo-query.GetOperations")
 	defer span.Finish()
 
-	return b.lookupTagValues(ctx, span, serviceSearchTag)
+	load := func(ctx context.Context) (interface{}, error) {
+		return b.lookupTagValues(ctx, serviceSearchTag)
+	}
+	if b.valuesCache == nil {
+		services, err := load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return services.([]string), nil
+	}
+
+	services, err := b.valuesCache.get(ctx, "services", refreshBackground, load)
+	if err != nil {
+		return nil, err
+	}
+	return services.([]string), nil
 }
 
 func (b *Backend) GetOperations(ctx context.Context, query jaeger_spanstore.OperationQueryParameters) ([]jaeger_spanstore.Operation, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "tempo-query.GetOperations")
 	defer span.Finish()
 
-	tagValues, err := b.lookupTagValues(ctx, span, operationSearchTag)
+	load := func(ctx context.Context) (interface{}, error) {
+		return b.loadOperations(ctx, query)
+	}
+	if b.valuesCache == nil {
+		operations, err := load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return operations.([]jaeger_spanstore.Operation), nil
+	}
+
+	// operations of a service sample its recent traces, which is too expensive to keep reloading
+	// for every service ever asked for
+	refresh := refreshBackground
+	if query.ServiceName != "" {
+		refresh = refreshOnDemand
+	}
+	operations, err := b.valuesCache.get(ctx, fmt.Sprintf("operations/%s/%s", query.ServiceName, query.SpanKind), refresh, load)
+	if err != nil {
+		return nil, err
+	}
+	return operations.([]jaeger_spanstore.Operation), nil
+}
+
+func (b *Backend) loadOperations(ctx context.Context, query jaeger_spanstore.OperationQueryParameters) ([]jaeger_spanstore.Operation, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "tempo-query.LoadOperations")
+	defer span.Finish()
+
+	operations, ok, err := b.scopedOperations(ctx, query)
+	if err != nil || ok {
+		return operations, err
+	}
+	// sampling traces is too slow to do on every page load, it is only done to fill the cache
+	if query.ServiceName != "" && b.valuesCache != nil {
+		return b.serviceOperations(ctx, span, query)
+	}
+
+	// without a way to filter operations by service or span kind, those of every service are listed
+	tagValues, err := b.lookupTagValues(ctx, operationSearchTag)
 	if err != nil {
 		return nil, err
 	}
 
-	var operations []jaeger_spanstore.Operation
 	for _, value := range tagValues {
 		operations = append(operations, jaeger_spanstore.Operation{
 			Name:     value,
@@ -141,13 +304,20 @@ func (b *Backend) GetOperations(ctx context.Context, query jaeger_spanstore.Oper
 	}
 
 	return operations, nil
-
 }
 
 func (b *Backend) FindTraces(ctx context.Context, query *jaeger_spanstore.TraceQueryParameters) ([]*jaeger.Trace, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "tempo-query.FindTraces")
 	defer span.Finish()
 
+	if b.summaryOnly {
+		searchResponse, err := b.searchTraces(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		return summaryTraces(searchResponse.Traces)
+	}
+
 	traceIDs, err := b.FindTraceIDs(ctx, query)
 	if err != nil {
 		return nil, err
@@ -155,17 +325,9 @@ func (b *Backend) FindTraces(ctx context.Context, query *jaeger_spanstore.TraceQ
 
 	span.LogFields(ot_log.String("msg", fmt.Sprintf("Found %d trace IDs", len(traceIDs))))
 
-	// for every traceID, get the full trace
-	var jaegerTraces []*jaeger.Trace
-	for _, traceID := range traceIDs {
-		trace, err := b.GetTrace(ctx, traceID)
-		if err != nil {
-			// TODO this seems to be an internal inconsistency error, ignore so we can still show the rest
-			span.LogFields(ot_log.Error(fmt.Errorf("could not get trace for traceID %v: %w", traceID, err)))
-			continue
-		}
-
-		jaegerTraces = append(jaegerTraces, trace)
+	jaegerTraces, err := b.hydrate(ctx, span, traceIDs)
+	if err != nil {
+		return nil, err
 	}
 
 	span.LogFields(ot_log.String("msg", fmt.Sprintf("Returning %d traces", len(jaegerTraces))))
@@ -173,54 +335,60 @@ func (b *Backend) FindTraces(ctx context.Context, query *jaeger_spanstore.TraceQ
 	return jaegerTraces, nil
 }
 
-func (b *Backend) FindTraceIDs(ctx context.Context, query *jaeger_spanstore.TraceQueryParameters) ([]jaeger.TraceID, error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "tempo-query.FindTraceIDs")
-	defer span.Finish()
+// hydrate fetches the full traces of a search, hydrationWorkers at a time, keeping the order of
+// the search. Traces that can't be fetched are left out.
+func (b *Backend) hydrate(ctx context.Context, span opentracing.Span, traceIDs []jaeger.TraceID) ([]*jaeger.Trace, error) {
+	traces := make([]*jaeger.Trace, len(traceIDs))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, b.hydrationWorkers)
+	for i, traceID := range traceIDs {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return nil, ctx.Err()
+		}
 
-	url := url.URL{
-		Scheme: "http",
-		Host:   b.tempoBackend,
-		Path:   "api/search",
-	}
-	urlQuery := url.Query()
-	urlQuery.Set(serviceSearchTag, query.ServiceName)
-	urlQuery.Set(operationSearchTag, query.OperationName)
-	urlQuery.Set(minDurationSearchTag, query.DurationMin.String())
-	urlQuery.Set(maxDurationSearchTag, query.DurationMax.String())
-	urlQuery.Set(numTracesSearchTag, strconv.Itoa(query.NumTraces))
-	for k, v := range query.Tags {
-		urlQuery.Set(k, v)
+		wg.Add(1)
+		go func(i int, traceID jaeger.TraceID) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			trace, err := b.GetTrace(ctx, traceID)
+			if err != nil {
+				// TODO this seems to be an internal inconsistency error, ignore so we can still show the rest
+				span.LogFields(ot_log.Error(fmt.Errorf("could not get trace for traceID %v: %w", traceID, err)))
+				return
+			}
+			traces[i] = trace
+		}(i, traceID)
 	}
-	url.RawQuery = urlQuery.Encode()
+	wg.Wait()
 
-	req, err := b.newGetRequest(ctx, url.String(), span)
-	if err != nil {
+	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed GET to tempo %w", err)
+	var jaegerTraces []*jaeger.Trace
+	for _, trace := range traces {
+		if trace != nil {
+			jaegerTraces = append(jaegerTraces, trace)
+		}
 	}
-	defer resp.Body.Close()
 
-	// if search endpoint returns 404, search is most likely not enabled
-	if resp.StatusCode == http.StatusNotFound {
-		return nil, nil
-	}
+	return jaegerTraces, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return nil, fmt.Errorf("error reading response from Tempo: got %s", resp.Status)
-		}
-		return nil, fmt.Errorf("%s", body)
-	}
+func (b *Backend) FindTraceIDs(ctx context.Context, query *jaeger_spanstore.TraceQueryParameters) ([]jaeger.TraceID, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "tempo-query.FindTraceIDs")
+	defer span.Finish()
 
-	var searchResponse tempopb.SearchResponse
-	err = jsonpb.Unmarshal(resp.Body, &searchResponse)
+	searchResponse, err := b.searchTraces(ctx, query)
 	if err != nil {
-		return nil, fmt.Errorf("error unmarshaling Tempo response: %w", err)
+		return nil, err
 	}
 
 	jaegerTraceIDs := make([]jaeger.TraceID, len(searchResponse.Traces))
@@ -236,60 +404,137 @@ func (b *Backend) FindTraceIDs(ctx context.Context, query *jaeger_spanstore.Trac
 	return jaegerTraceIDs, nil
 }
 
-func (b *Backend) lookupTagValues(ctx context.Context, span opentracing.Span, tagName string) ([]string, error) {
-	url := fmt.Sprintf("http://%s/api/search/tag/%s/values", b.tempoBackend, tagName)
+// searchTraces searches Tempo for the traces matching a Jaeger query.
+func (b *Backend) searchTraces(ctx context.Context, query *jaeger_spanstore.TraceQueryParameters) (*tempopb.SearchResponse, error) {
+	// tags left empty in the Jaeger UI are not sent, Tempo would look for empty values
+	tags := map[string]string{}
+	if query.ServiceName != "" {
+		tags[serviceSearchTag] = query.ServiceName
+	}
+	if query.OperationName != "" {
+		tags[operationSearchTag] = query.OperationName
+	}
+	for k, v := range query.Tags {
+		if v == "" {
+			continue
+		}
+		k, v = b.tagTranslator.translate(k, v)
+		tags[k] = v
+	}
+	// a TraceQL query replaces every other filter, the Jaeger UI always asks for a service though
+	if traceQL, ok := query.Tags[traceQLSearchTag]; ok {
+		tags = map[string]string{traceQLSearchTag: traceQL}
+	}
+
+	searchReq := &tempopb.SearchRequest{
+		Tags:          tags,
+		MinDurationMs: uint32(query.DurationMin.Milliseconds()),
+		MaxDurationMs: uint32(query.DurationMax.Milliseconds()),
+		Limit:         uint32(query.NumTraces),
+	}
+	if !query.StartTimeMin.IsZero() && !query.StartTimeMax.IsZero() {
+		searchReq.Start = uint32(query.StartTimeMin.Unix())
+		// end is exclusive and in seconds, round up to keep traces of the last second
+		searchReq.End = uint32(query.StartTimeMax.Add(time.Second - 1).Unix())
+	}
+
+	return b.search(ctx, searchReq)
+}
 
-	req, err := b.newGetRequest(ctx, url, span)
+// findTraceByID fetches a trace from the blocks of the time it was last seen in a search, and
+// from all blocks if it wasn't seen or isn't found there.
+func (b *Backend) findTraceByID(ctx context.Context, span opentracing.Span, traceID jaeger.TraceID) (pdata.Traces, error) {
+	if b.traceHints != nil {
+		if window, ok := b.traceHints.get(ctx, traceID); ok {
+			otTrace, err := b.querier.findTraceByID(ctx, traceID, window)
+			if err != jaeger_spanstore.ErrTraceNotFound {
+				return otTrace, err
+			}
+			span.LogFields(ot_log.String("msg", "trace not found in hinted window, retrying unbounded"))
+		}
+	}
+
+	return b.querier.findTraceByID(ctx, traceID, searchWindow{})
+}
+
+// search runs a search against Tempo, splitting time ranges longer than the maximum search window.
+func (b *Backend) search(ctx context.Context, req *tempopb.SearchRequest) (*tempopb.SearchResponse, error) {
+	resp, err := b.coalescer.do(ctx, "search/"+req.String(), func(ctx context.Context) (interface{}, error) {
+		return b.searchUncoalesced(ctx, req)
+	})
 	if err != nil {
 		return nil, err
 	}
+	return resp.(*tempopb.SearchResponse), nil
+}
 
-	resp, err := http.DefaultClient.Do(req)
+func (b *Backend) searchUncoalesced(ctx context.Context, req *tempopb.SearchRequest) (*tempopb.SearchResponse, error) {
+	var resp *tempopb.SearchResponse
+	var err error
+	if req.Start == 0 && req.End == 0 {
+		resp, err = b.querier.search(ctx, req)
+	} else {
+		resp, err = b.searchWindows(ctx, req, splitSearchWindow(req.Start, req.End, b.maxSearchWindow, b.searchShards))
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed GET to tempo %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
+	resp.Traces = sortSearchResults(resp.Traces)
 
-	// if search endpoint returns 404, search is most likely not enabled
-	if resp.StatusCode == http.StatusNotFound {
-		return nil, nil
+	if b.traceHints != nil {
+		b.traceHints.add(ctx, resp)
 	}
+	return resp, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return nil, fmt.Errorf("error reading response from Tempo: got %s", resp.Status)
-		}
-		return nil, fmt.Errorf("%s", body)
+func (b *Backend) lookupTagValues(ctx context.Context, tagName string) ([]string, error) {
+	values, err := b.coalescer.do(ctx, "tag-values/"+tagName, func(ctx context.Context) (interface{}, error) {
+		return b.querier.searchTagValues(ctx, tagName)
+	})
+	if err != nil {
+		return nil, err
 	}
+	return values.([]string), nil
+}
 
-	var searchLookupResponse tempopb.SearchTagValuesResponse
-	err = jsonpb.Unmarshal(resp.Body, &searchLookupResponse)
-	if err != nil {
-		return nil, fmt.Errorf("error unmarshaling Tempo response: %w", err)
+func (b *Backend) WriteSpan(ctx context.Context, span *jaeger.Span) error {
+	if b.writer == nil {
+		return errWriteDisabled
 	}
 
-	return searchLookupResponse.TagValues, nil
+	return b.writer.enqueue(ctx, span)
 }
 
-func (b *Backend) WriteSpan(ctx context.Context, span *jaeger.Span) error {
-	return nil
+// Close stops background work, flushes queued spans and releases the connections held by the backend.
+func (b *Backend) Close() error {
+	if b.aggregator != nil {
+		b.aggregator.close()
+	}
+	if b.valuesCache != nil {
+		b.valuesCache.close()
+	}
+	if b.writer != nil {
+		if err := b.writer.close(); err != nil {
+			return err
+		}
+	}
+	return b.querier.close()
 }
 
-func (b *Backend) newGetRequest(ctx context.Context, url string, span opentracing.Span) (*http.Request, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+func newRequest(ctx context.Context, method string, url string, body io.Reader, span opentracing.Span) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
 		return nil, err
 	}
 
-	if tracer := opentracing.GlobalTracer(); tracer != nil {
+	if tracer := opentracing.GlobalTracer(); tracer != nil && span != nil {
 		// this is not really loggable or anything we can react to.  just ignoring this error
 		_ = tracer.Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
 	}
 
 	// currently Jaeger Query will only propagate bearer token to the grpc backend and no other headers
 	// so we are going to extract the tenant id from the header, if it exists and use it
-	tenantID, found := extractBearerToken(ctx)
+	tenantID, found := extractTenantID(ctx)
 	if found {
 		req.Header.Set(user.OrgIDHeaderName, tenantID)
 	}
@@ -297,6 +542,15 @@ func (b *Backend) newGetRequest(ctx context.Context, url string, span opentracin
 	return req, nil
 }
 
+// extractTenantID returns the tenant a request is made for, either injected by the plugin itself
+// or propagated by Jaeger as a bearer token.
+func extractTenantID(ctx context.Context) (string, bool) {
+	if orgID, err := user.ExtractOrgID(ctx); err == nil {
+		return orgID, true
+	}
+	return extractBearerToken(ctx)
+}
+
 func extractBearerToken(ctx context.Context) (string, bool) {
 	if md, ok := metadata.FromIncomingContext(ctx); ok {
 		values := md.Get(shared.BearerTokenKey)
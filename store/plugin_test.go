@@ -0,0 +1,110 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/tempo/pkg/tempopb"
+	"github.com/opentracing/opentracing-go"
+
+	jaeger "github.com/jaegertracing/jaeger/model"
+
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+// fakeQuerier answers from fixed traces and search responses.
+type fakeQuerier struct {
+	traces   pdata.Traces
+	searchFn func(req *tempopb.SearchRequest) (*tempopb.SearchResponse, error)
+}
+
+func (q *fakeQuerier) findTraceByID(_ context.Context, _ jaeger.TraceID, _ searchWindow) (pdata.Traces, error) {
+	return q.traces, nil
+}
+
+func (q *fakeQuerier) search(_ context.Context, req *tempopb.SearchRequest) (*tempopb.SearchResponse, error) {
+	return q.searchFn(req)
+}
+
+func (q *fakeQuerier) searchTagValues(_ context.Context, _ string) ([]string, error) {
+	return nil, nil
+}
+
+func (q *fakeQuerier) searchScopedTagValues(_ context.Context, _ string, _ string) ([]string, bool, error) {
+	return nil, false, nil
+}
+
+func (q *fakeQuerier) close() error {
+	return nil
+}
+
+func TestFetchTraceProcessMap(t *testing.T) {
+	traceID := jaeger.NewTraceID(0, 1)
+	process := func(host, ip string) *jaeger.Process {
+		return jaeger.NewProcess("frontend", []jaeger.KeyValue{
+			jaeger.String("host.name", host),
+			jaeger.String("ip", ip),
+		})
+	}
+	batch := func(process *jaeger.Process, spanIDs ...uint64) *jaeger.Batch {
+		b := &jaeger.Batch{Process: process}
+		for _, id := range spanIDs {
+			b.Spans = append(b.Spans, &jaeger.Span{TraceID: traceID, SpanID: jaeger.NewSpanID(id), OperationName: "op"})
+		}
+		return b
+	}
+
+	// replicas of the same service, and the first replica again with its tags in another order
+	sameAsFirst := jaeger.NewProcess("frontend", []jaeger.KeyValue{
+		jaeger.String("ip", "10.0.0.1"),
+		jaeger.String("host.name", "a"),
+	})
+	traces, err := batchToTraces(
+		batch(process("a", "10.0.0.1"), 1, 2),
+		batch(process("b", "10.0.0.2"), 3),
+		batch(sameAsFirst, 4),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &Backend{querier: &fakeQuerier{traces: traces}}
+	trace, err := b.fetchTrace(context.Background(), opentracing.NoopTracer{}.StartSpan("test"), traceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(trace.ProcessMap) != 2 {
+		t.Fatalf("expected 2 processes, got %v", trace.ProcessMap)
+	}
+	processes := map[string]jaeger.Process{}
+	for _, mapping := range trace.ProcessMap {
+		if _, ok := processes[mapping.ProcessID]; ok {
+			t.Fatalf("duplicate process id %s", mapping.ProcessID)
+		}
+		processes[mapping.ProcessID] = mapping.Process
+	}
+
+	spanProcesses := map[jaeger.SpanID]string{}
+	for _, span := range trace.Spans {
+		process, ok := processes[span.ProcessID]
+		if !ok {
+			t.Fatalf("span %v refers to unknown process %q", span.SpanID, span.ProcessID)
+		}
+		if processKey(&process) != processKey(span.Process) {
+			t.Errorf("span %v has process %v, but its process id refers to %v", span.SpanID, span.Process, process)
+		}
+		spanProcesses[span.SpanID] = span.ProcessID
+	}
+
+	if len(spanProcesses) != 4 {
+		t.Fatalf("expected 4 spans, got %d", len(spanProcesses))
+	}
+	first := spanProcesses[jaeger.NewSpanID(1)]
+	if spanProcesses[jaeger.NewSpanID(2)] != first || spanProcesses[jaeger.NewSpanID(4)] != first {
+		t.Errorf("expected spans of identical processes to share a process id, got %v", spanProcesses)
+	}
+	if spanProcesses[jaeger.NewSpanID(3)] == first {
+		t.Errorf("expected replicas with different tags to get their own process id, got %v", spanProcesses)
+	}
+}
@@ -0,0 +1,23 @@
+package store
+
+import (
+	"bytes"
+
+	jaeger "github.com/jaegertracing/jaeger/model"
+)
+
+// processKey identifies a process by its service name and tags, regardless of the order of the tags.
+func processKey(process *jaeger.Process) string {
+	if process == nil {
+		return ""
+	}
+
+	tags := make(jaeger.KeyValues, len(process.Tags))
+	copy(tags, process.Tags)
+	tags.Sort()
+
+	var buf bytes.Buffer
+	// writing to a bytes.Buffer never fails
+	_ = jaeger.NewProcess(process.ServiceName, tags).Hash(&buf)
+	return buf.String()
+}
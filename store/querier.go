@@ -0,0 +1,282 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gogo/protobuf/jsonpb"
+	"github.com/grafana/tempo/pkg/tempopb"
+	"github.com/hashicorp/go-hclog"
+	"github.com/opentracing/opentracing-go"
+
+	jaeger "github.com/jaegertracing/jaeger/model"
+	jaeger_spanstore "github.com/jaegertracing/jaeger/storage/spanstore"
+
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+const (
+	transportHTTP = "http"
+	transportGRPC = "grpc"
+)
+
+var errTraceQLUnsupportedVersion = errors.New("TraceQL queries need Tempo 2.0 or later")
+
+// querier is the transport the backend reads from Tempo with.
+type querier interface {
+	// findTraceByID returns jaeger_spanstore.ErrTraceNotFound if Tempo doesn't know the trace. A
+	// non-zero window limits the lookup to the blocks of that time.
+	findTraceByID(ctx context.Context, traceID jaeger.TraceID, window searchWindow) (pdata.Traces, error)
+	// search returns an empty response if search is not enabled in Tempo.
+	search(ctx context.Context, req *tempopb.SearchRequest) (*tempopb.SearchResponse, error)
+	// searchTagValues returns no values if search is not enabled in Tempo.
+	searchTagValues(ctx context.Context, tagName string) ([]string, error)
+	// searchScopedTagValues returns the values of a TraceQL tag, such as name, of the spans
+	// matching a TraceQL query. It returns false if Tempo can't filter tag values by a query.
+	searchScopedTagValues(ctx context.Context, tagName string, query string) ([]string, bool, error)
+	close() error
+}
+
+func newQuerier(cfg *Config, logger hclog.Logger) (querier, error) {
+	switch cfg.Transport {
+	case transportHTTP:
+		q := &httpQuerier{
+			tempoBackend: cfg.Backend,
+			// the v1 API is served by every version so far
+			api: tempoAPIV1,
+		}
+		if version, ok := probeTempoVersion(cfg.Backend, logger); ok {
+			q.api = tempoAPIForVersion(version)
+			q.version = &version
+		}
+		return q, nil
+	case transportGRPC:
+		return newGRPCQuerier(cfg.Backend)
+	default:
+		return nil, fmt.Errorf("unknown transport %q, must be one of %q or %q", cfg.Transport, transportHTTP, transportGRPC)
+	}
+}
+
+// httpQuerier reads from Tempo's HTTP API.
+type httpQuerier struct {
+	tempoBackend string
+	api          *tempoAPI
+	// version is nil if it couldn't be detected
+	version *tempoVersion
+}
+
+func (q *httpQuerier) findTraceByID(ctx context.Context, traceID jaeger.TraceID, window searchWindow) (pdata.Traces, error) {
+	url := fmt.Sprintf("http://%s"+q.api.traceByIDPath, q.tempoBackend, traceID)
+	if window != (searchWindow{}) {
+		url += fmt.Sprintf("?%s=%d&%s=%d", startSearchTag, window.start, endSearchTag, window.end)
+	}
+
+	req, err := newRequest(ctx, "GET", url, nil, opentracing.SpanFromContext(ctx))
+	if err != nil {
+		return pdata.Traces{}, err
+	}
+
+	req.Header.Set(AcceptHeaderKey, q.api.accept)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return pdata.Traces{}, fmt.Errorf("failed GET to tempo %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return pdata.Traces{}, jaeger_spanstore.ErrTraceNotFound
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return pdata.Traces{}, fmt.Errorf("error reading response from tempo: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return pdata.Traces{}, fmt.Errorf("%s", body)
+	}
+
+	otTrace, err := q.api.decodeTrace(resp.Header.Get(ContentTypeHeaderKey), body)
+	if err != nil {
+		return pdata.Traces{}, fmt.Errorf("error unmarshalling body to otlp trace %v: %w", traceID, err)
+	}
+	if otTrace.ResourceSpans().Len() == 0 {
+		return pdata.Traces{}, jaeger_spanstore.ErrTraceNotFound
+	}
+
+	return otTrace, nil
+}
+
+func (q *httpQuerier) search(ctx context.Context, searchReq *tempopb.SearchRequest) (*tempopb.SearchResponse, error) {
+	urlQuery := url.Values{}
+	for k, v := range searchReq.Tags {
+		if k == traceQLSearchTag {
+			// older versions ignore the query and would search for everything instead
+			if q.version != nil && !q.version.atLeast(2, 0) {
+				return nil, errTraceQLUnsupportedVersion
+			}
+			k = traceQLQueryParam
+		}
+		urlQuery.Set(k, v)
+	}
+	// only the filters that are set are sent
+	if searchReq.MinDurationMs > 0 {
+		urlQuery.Set(minDurationSearchTag, formatDurationMs(searchReq.MinDurationMs))
+	}
+	if searchReq.MaxDurationMs > 0 {
+		urlQuery.Set(maxDurationSearchTag, formatDurationMs(searchReq.MaxDurationMs))
+	}
+	if searchReq.Limit > 0 {
+		urlQuery.Set(numTracesSearchTag, strconv.FormatUint(uint64(searchReq.Limit), 10))
+	}
+	if searchReq.Start != 0 || searchReq.End != 0 {
+		urlQuery.Set(startSearchTag, strconv.FormatUint(uint64(searchReq.Start), 10))
+		urlQuery.Set(endSearchTag, strconv.FormatUint(uint64(searchReq.End), 10))
+	}
+
+	url := url.URL{
+		Scheme:   "http",
+		Host:     q.tempoBackend,
+		Path:     "api/search",
+		RawQuery: urlQuery.Encode(),
+	}
+
+	req, err := newRequest(ctx, "GET", url.String(), nil, opentracing.SpanFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed GET to tempo %w", err)
+	}
+	defer resp.Body.Close()
+
+	// if search endpoint returns 404, search is most likely not enabled
+	if resp.StatusCode == http.StatusNotFound {
+		return &tempopb.SearchResponse{}, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error reading response from Tempo: got %s", resp.Status)
+		}
+		return nil, fmt.Errorf("%s", body)
+	}
+
+	var searchResponse tempopb.SearchResponse
+	err = jsonpb.Unmarshal(resp.Body, &searchResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling Tempo response: %w", err)
+	}
+
+	return &searchResponse, nil
+}
+
+// formatDurationMs formats a duration the way Tempo parses it, always in milliseconds such as
+// "1500ms", rather than time.Duration's "1.5s" or "1m30s".
+func formatDurationMs(ms uint32) string {
+	return strconv.FormatUint(uint64(ms), 10) + "ms"
+}
+
+func (q *httpQuerier) searchTagValues(ctx context.Context, tagName string) ([]string, error) {
+	url := fmt.Sprintf("http://%s/api/search/tag/%s/values", q.tempoBackend, tagName)
+
+	req, err := newRequest(ctx, "GET", url, nil, opentracing.SpanFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed GET to tempo %w", err)
+	}
+	defer resp.Body.Close()
+
+	// if search endpoint returns 404, search is most likely not enabled
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error reading response from Tempo: got %s", resp.Status)
+		}
+		return nil, fmt.Errorf("%s", body)
+	}
+
+	var searchLookupResponse tempopb.SearchTagValuesResponse
+	err = jsonpb.Unmarshal(resp.Body, &searchLookupResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling Tempo response: %w", err)
+	}
+
+	return searchLookupResponse.TagValues, nil
+}
+
+func (q *httpQuerier) searchScopedTagValues(ctx context.Context, tagName string, query string) ([]string, bool, error) {
+	// tag values are only filtered by a query since Tempo 2.3
+	if q.version == nil || !q.version.atLeast(2, 3) {
+		return nil, false, nil
+	}
+
+	url := url.URL{
+		Scheme:   "http",
+		Host:     q.tempoBackend,
+		Path:     fmt.Sprintf("api/v2/search/tag/%s/values", tagName),
+		RawQuery: url.Values{traceQLQueryParam: {query}}.Encode(),
+	}
+
+	req, err := newRequest(ctx, "GET", url.String(), nil, opentracing.SpanFromContext(ctx))
+	if err != nil {
+		return nil, false, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed GET to tempo %w", err)
+	}
+	defer resp.Body.Close()
+
+	// if search endpoint returns 404, search is most likely not enabled
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, fmt.Errorf("error reading response from Tempo: got %s", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("%s", body)
+	}
+
+	// the vendored tempopb predates the v2 response, which types every value
+	var searchLookupResponse struct {
+		TagValues []struct {
+			Value string `json:"value"`
+		} `json:"tagValues"`
+	}
+	if err := json.Unmarshal(body, &searchLookupResponse); err != nil {
+		return nil, false, fmt.Errorf("error unmarshaling Tempo response: %w", err)
+	}
+
+	values := make([]string, 0, len(searchLookupResponse.TagValues))
+	for _, value := range searchLookupResponse.TagValues {
+		values = append(values, value.Value)
+	}
+	return values, true, nil
+}
+
+func (q *httpQuerier) close() error {
+	return nil
+}
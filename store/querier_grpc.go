@@ -0,0 +1,144 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/grafana/tempo/pkg/tempopb"
+	otgrpc "github.com/opentracing-contrib/go-grpc"
+	"github.com/opentracing/opentracing-go"
+	"github.com/weaveworks/common/user"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	jaeger "github.com/jaegertracing/jaeger/model"
+	jaeger_spanstore "github.com/jaegertracing/jaeger/storage/spanstore"
+
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+const (
+	// grpcMaxRecvMsgSize leaves room for large traces, gRPC only accepts 4MiB by default
+	grpcMaxRecvMsgSize = 100 << 20
+
+	grpcQueryModeAll = "all"
+)
+
+var errTraceQLUnsupported = errors.New("TraceQL queries are not supported by the grpc transport, set transport to http")
+
+// grpcQuerier reads from the gRPC API of a Tempo querier.
+type grpcQuerier struct {
+	conn   *grpc.ClientConn
+	client tempopb.QuerierClient
+}
+
+func newGRPCQuerier(endpoint string) (*grpcQuerier, error) {
+	conn, err := grpc.Dial(endpoint,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(otgrpc.OpenTracingClientInterceptor(opentracing.GlobalTracer())),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(grpcMaxRecvMsgSize)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial tempo querier %s: %w", endpoint, err)
+	}
+
+	return &grpcQuerier{
+		conn:   conn,
+		client: tempopb.NewQuerierClient(conn),
+	}, nil
+}
+
+// withTenant propagates the tenant of the request to the querier.
+func (q *grpcQuerier) withTenant(ctx context.Context) (context.Context, error) {
+	tenantID, found := extractTenantID(ctx)
+	if !found {
+		return ctx, nil
+	}
+	return user.InjectIntoGRPCRequest(user.InjectOrgID(ctx, tenantID))
+}
+
+// findTraceByID always looks at every block, the querier API takes no time range.
+func (q *grpcQuerier) findTraceByID(ctx context.Context, traceID jaeger.TraceID, _ searchWindow) (pdata.Traces, error) {
+	ctx, err := q.withTenant(ctx)
+	if err != nil {
+		return pdata.Traces{}, err
+	}
+
+	id := make([]byte, 16)
+	if _, err := traceID.MarshalTo(id); err != nil {
+		return pdata.Traces{}, err
+	}
+
+	resp, err := q.client.FindTraceByID(ctx, &tempopb.TraceByIDRequest{
+		TraceID:   id,
+		QueryMode: grpcQueryModeAll,
+	})
+	if status.Code(err) == codes.NotFound {
+		return pdata.Traces{}, jaeger_spanstore.ErrTraceNotFound
+	}
+	if err != nil {
+		return pdata.Traces{}, fmt.Errorf("failed FindTraceByID to tempo %w", err)
+	}
+	if resp.Trace == nil || len(resp.Trace.Batches) == 0 {
+		return pdata.Traces{}, jaeger_spanstore.ErrTraceNotFound
+	}
+
+	otTrace, err := tempoTraceToOTLP(resp.Trace)
+	if err != nil {
+		return pdata.Traces{}, fmt.Errorf("error unmarshalling body to otlp trace %v: %w", traceID, err)
+	}
+
+	return otTrace, nil
+}
+
+func (q *grpcQuerier) search(ctx context.Context, req *tempopb.SearchRequest) (*tempopb.SearchResponse, error) {
+	if _, ok := req.Tags[traceQLSearchTag]; ok {
+		return nil, errTraceQLUnsupported
+	}
+
+	ctx, err := q.withTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := q.client.SearchRecent(ctx, req)
+	// search is most likely not enabled
+	if status.Code(err) == codes.Unimplemented {
+		return &tempopb.SearchResponse{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed SearchRecent to tempo %w", err)
+	}
+
+	return resp, nil
+}
+
+func (q *grpcQuerier) searchTagValues(ctx context.Context, tagName string) ([]string, error) {
+	ctx, err := q.withTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := q.client.SearchTagValues(ctx, &tempopb.SearchTagValuesRequest{TagName: tagName})
+	// search is most likely not enabled
+	if status.Code(err) == codes.Unimplemented {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed SearchTagValues to tempo %w", err)
+	}
+
+	return resp.TagValues, nil
+}
+
+// searchScopedTagValues is not supported, Tempo's gRPC API can't filter tag values by a query.
+func (q *grpcQuerier) searchScopedTagValues(ctx context.Context, tagName string, query string) ([]string, bool, error) {
+	return nil, false, nil
+}
+
+func (q *grpcQuerier) close() error {
+	return q.conn.Close()
+}
@@ -0,0 +1,172 @@
+package store
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/tempo/pkg/tempopb"
+
+	jaeger_spanstore "github.com/jaegertracing/jaeger/storage/spanstore"
+)
+
+// newFakeTempo serves empty search results and records the query string of every search.
+func newFakeTempo(t *testing.T, queries *[]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/search" {
+			http.NotFound(w, r)
+			return
+		}
+		*queries = append(*queries, r.URL.RawQuery)
+		w.Header().Set(ContentTypeHeaderKey, JSONTypeHeaderValue)
+		_, _ = w.Write([]byte(`{"traces":[]}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHTTPQuerierTraceQLVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version *tempoVersion
+		err     error
+	}{
+		{name: "unknown version", version: nil},
+		{name: "tempo 1.5", version: &tempoVersion{major: 1, minor: 5}, err: errTraceQLUnsupportedVersion},
+		{name: "tempo 2.0", version: &tempoVersion{major: 2, minor: 0}},
+		{name: "tempo 2.5", version: &tempoVersion{major: 2, minor: 5}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var queries []string
+			server := newFakeTempo(t, &queries)
+
+			q := &httpQuerier{
+				tempoBackend: strings.TrimPrefix(server.URL, "http://"),
+				api:          tempoAPIV1,
+				version:      tc.version,
+			}
+			_, err := q.search(context.Background(), &tempopb.SearchRequest{
+				Tags: map[string]string{traceQLSearchTag: "{ span.http.status_code >= 500 }"},
+			})
+			if err != tc.err {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+			if sent := len(queries) > 0; sent != (tc.err == nil) {
+				t.Errorf("expected the search to be sent: %v, got %v", tc.err == nil, sent)
+			}
+		})
+	}
+}
+
+func TestHTTPQuerierSearchQuery(t *testing.T) {
+	start := time.Unix(1650000000, 0)
+
+	tests := []struct {
+		name     string
+		query    jaeger_spanstore.TraceQueryParameters
+		expected url.Values
+	}{
+		{
+			name:     "empty service and operation",
+			query:    jaeger_spanstore.TraceQueryParameters{Tags: map[string]string{"http.method": "GET", "http.url": ""}},
+			expected: url.Values{"http.method": {"GET"}},
+		},
+		{
+			name:  "service and operation",
+			query: jaeger_spanstore.TraceQueryParameters{ServiceName: "frontend", OperationName: "GET /"},
+			expected: url.Values{
+				serviceSearchTag:   {"frontend"},
+				operationSearchTag: {"GET /"},
+			},
+		},
+		{
+			name:     "zero durations",
+			query:    jaeger_spanstore.TraceQueryParameters{ServiceName: "frontend"},
+			expected: url.Values{serviceSearchTag: {"frontend"}},
+		},
+		{
+			name: "durations",
+			query: jaeger_spanstore.TraceQueryParameters{
+				ServiceName: "frontend",
+				DurationMin: 1500 * time.Millisecond,
+				DurationMax: 2 * time.Minute,
+			},
+			expected: url.Values{
+				serviceSearchTag:     {"frontend"},
+				minDurationSearchTag: {"1500ms"},
+				maxDurationSearchTag: {"120000ms"},
+			},
+		},
+		{
+			name:  "limit",
+			query: jaeger_spanstore.TraceQueryParameters{ServiceName: "frontend", NumTraces: 20},
+			expected: url.Values{
+				serviceSearchTag:   {"frontend"},
+				numTracesSearchTag: {"20"},
+			},
+		},
+		{
+			name: "start and end",
+			query: jaeger_spanstore.TraceQueryParameters{
+				ServiceName:  "frontend",
+				StartTimeMin: start,
+				StartTimeMax: start.Add(30*time.Minute + 500*time.Millisecond),
+			},
+			expected: url.Values{
+				serviceSearchTag: {"frontend"},
+				startSearchTag:   {"1650000000"},
+				// rounded up to the next second
+				endSearchTag: {"1650001801"},
+			},
+		},
+		{
+			name: "traceql",
+			query: jaeger_spanstore.TraceQueryParameters{
+				ServiceName: "frontend",
+				Tags:        map[string]string{traceQLSearchTag: "{ span.http.status_code >= 500 }", "http.method": "GET"},
+				NumTraces:   20,
+			},
+			expected: url.Values{
+				traceQLQueryParam:  {"{ span.http.status_code >= 500 }"},
+				numTracesSearchTag: {"20"},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var queries []string
+			server := newFakeTempo(t, &queries)
+
+			b := &Backend{
+				querier:          &httpQuerier{tempoBackend: strings.TrimPrefix(server.URL, "http://"), api: tempoAPIV1},
+				searchShards:     1,
+				shardConcurrency: 1,
+				tagTranslator:    newTagTranslator(nil),
+				coalescer:        newCoalescer(),
+			}
+			if _, err := b.searchTraces(context.Background(), &tc.query); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(queries) != 1 {
+				t.Fatalf("expected 1 search, got %d", len(queries))
+			}
+			values, err := url.ParseQuery(queries[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(values, tc.expected) {
+				t.Errorf("expected query %v, got %v", tc.expected, values)
+			}
+		})
+	}
+}
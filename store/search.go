@@ -0,0 +1,218 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/grafana/tempo/pkg/tempopb"
+)
+
+// searchWindow is a time range of a search in unix seconds.
+type searchWindow struct {
+	start uint32
+	end   uint32
+}
+
+// splitSearchWindow splits the range of a search into shards windows of equal length, newest
+// first, making them shorter where needed to not exceed maxWindow seconds. A maxWindow of 0
+// doesn't limit the windows.
+func splitSearchWindow(start, end, maxWindow uint32, shards int) []searchWindow {
+	if end <= start {
+		return []searchWindow{{start: start, end: end}}
+	}
+
+	size := maxWindow
+	if shards > 1 {
+		shardSize := (end - start + uint32(shards) - 1) / uint32(shards)
+		if size == 0 || shardSize < size {
+			size = shardSize
+		}
+	}
+	if size == 0 || end-start <= size {
+		return []searchWindow{{start: start, end: end}}
+	}
+
+	var windows []searchWindow
+	for windowEnd := end; ; windowEnd -= size {
+		if windowEnd-start <= size {
+			return append(windows, searchWindow{start: start, end: windowEnd})
+		}
+		windows = append(windows, searchWindow{start: windowEnd - size, end: windowEnd})
+	}
+}
+
+// searchWindows runs a search as one request per window, shardConcurrency at a time. Windows
+// split off by max_window are each asked for the whole limit, as the range was asked for as a whole.
+// Shards are each asked for an equal part of it, so the results are spread over the whole range
+// rather than bunched in one part of it, and the part sparse shards leave unused is asked for
+// again from the others. The results of every window are merged, and traces found in several
+// windows are only returned once.
+func (b *Backend) searchWindows(ctx context.Context, req *tempopb.SearchRequest, windows []searchWindow) (*tempopb.SearchResponse, error) {
+	if len(windows) == 1 {
+		windowReq := *req
+		windowReq.Start = windows[0].start
+		windowReq.End = windows[0].end
+		return b.querier.search(ctx, &windowReq)
+	}
+
+	limits := make([]uint32, len(windows))
+	pending := make([]int, len(windows))
+	for i := range windows {
+		limits[i] = req.Limit
+		if b.searchShards > 1 && req.Limit > 0 {
+			limits[i] = (req.Limit + uint32(len(windows)) - 1) / uint32(len(windows))
+		}
+		pending[i] = i
+	}
+
+	results := make([]*tempopb.SearchResponse, len(windows))
+	metrics := &tempopb.SearchMetrics{}
+	for len(pending) > 0 {
+		if err := b.searchWindowsOnce(ctx, req, windows, limits, pending, results); err != nil {
+			return nil, err
+		}
+		for _, i := range pending {
+			addSearchMetrics(metrics, results[i].Metrics)
+		}
+		pending = growWindowLimits(req.Limit, limits, results)
+	}
+
+	merged := &tempopb.SearchResponse{Metrics: metrics}
+	for _, resp := range results {
+		merged.Traces = append(merged.Traces, resp.Traces...)
+	}
+	merged.Traces = sortSearchResults(merged.Traces)
+	if req.Limit > 0 && len(merged.Traces) > int(req.Limit) {
+		merged.Traces = merged.Traces[:req.Limit]
+	}
+
+	return merged, nil
+}
+
+// searchWindowsOnce searches the windows at the given indices with their limits, storing the
+// responses at the same indices of results. The first failure cancels the other requests.
+func (b *Backend) searchWindowsOnce(ctx context.Context, req *tempopb.SearchRequest, windows []searchWindow, limits []uint32, indices []int, results []*tempopb.SearchResponse) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mtx      sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	sem := make(chan struct{}, b.shardConcurrency)
+	for _, i := range indices {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		windowReq := *req
+		windowReq.Start = windows[i].start
+		windowReq.End = windows[i].end
+		windowReq.Limit = limits[i]
+
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			resp, err := b.querier.search(ctx, &windowReq)
+			if err != nil {
+				mtx.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mtx.Unlock()
+				cancel()
+				return
+			}
+			results[i] = resp
+		}(i)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	// the search was cancelled by the caller
+	return ctx.Err()
+}
+
+// growWindowLimits hands the part of the limit left unused by windows with fewer traces than they
+// were asked for to the windows that returned as many as they were asked for, and may hold more.
+// It returns the windows to search again with their grown limits, none once the limit is reached
+// or every window is exhausted.
+func growWindowLimits(limit uint32, limits []uint32, results []*tempopb.SearchResponse) []int {
+	if limit == 0 {
+		return nil
+	}
+
+	found := map[string]struct{}{}
+	var full []int
+	for i, resp := range results {
+		for _, trace := range resp.Traces {
+			found[trace.TraceID] = struct{}{}
+		}
+		// no window needs more than the whole limit
+		if uint32(len(resp.Traces)) >= limits[i] && limits[i] < limit {
+			full = append(full, i)
+		}
+	}
+	if uint32(len(found)) >= limit || len(full) == 0 {
+		return nil
+	}
+
+	unused := limit - uint32(len(found))
+	extra := (unused + uint32(len(full)) - 1) / uint32(len(full))
+	for _, i := range full {
+		limits[i] += extra
+		if limits[i] > limit {
+			limits[i] = limit
+		}
+	}
+	return full
+}
+
+// sortSearchResults removes duplicate traces, as reported by both ingesters and blocks, and sorts
+// the traces newest first, by trace ID where they started at the same time, so a search returns
+// the same page every time. Of duplicates the one starting first is kept, its root is more likely
+// to be known.
+func sortSearchResults(traces []*tempopb.TraceSearchMetadata) []*tempopb.TraceSearchMetadata {
+	unique := make(map[string]*tempopb.TraceSearchMetadata, len(traces))
+	for _, trace := range traces {
+		if seen, ok := unique[trace.TraceID]; ok && seen.StartTimeUnixNano <= trace.StartTimeUnixNano {
+			continue
+		}
+		unique[trace.TraceID] = trace
+	}
+
+	sorted := make([]*tempopb.TraceSearchMetadata, 0, len(unique))
+	for _, trace := range unique {
+		sorted = append(sorted, trace)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].StartTimeUnixNano != sorted[j].StartTimeUnixNano {
+			return sorted[i].StartTimeUnixNano > sorted[j].StartTimeUnixNano
+		}
+		return sorted[i].TraceID < sorted[j].TraceID
+	})
+	return sorted
+}
+
+func addSearchMetrics(total, metrics *tempopb.SearchMetrics) {
+	if metrics == nil {
+		return
+	}
+	total.InspectedTraces += metrics.InspectedTraces
+	total.InspectedBytes += metrics.InspectedBytes
+	total.InspectedBlocks += metrics.InspectedBlocks
+	total.SkippedBlocks += metrics.SkippedBlocks
+	total.SkippedTraces += metrics.SkippedTraces
+}
@@ -0,0 +1,173 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/grafana/tempo/pkg/tempopb"
+)
+
+// searchDataset answers searches from traces starting at the given unix seconds, newest first,
+// and records the limit every window was asked for.
+type searchDataset struct {
+	starts []uint32
+
+	mtx    sync.Mutex
+	limits map[searchWindow][]uint32
+}
+
+func (d *searchDataset) search(req *tempopb.SearchRequest) (*tempopb.SearchResponse, error) {
+	d.mtx.Lock()
+	window := searchWindow{start: req.Start, end: req.End}
+	d.limits[window] = append(d.limits[window], req.Limit)
+	d.mtx.Unlock()
+
+	starts := append([]uint32(nil), d.starts...)
+	sort.Slice(starts, func(i, j int) bool { return starts[i] > starts[j] })
+
+	resp := &tempopb.SearchResponse{Metrics: &tempopb.SearchMetrics{InspectedTraces: 1}}
+	for _, start := range starts {
+		if start < req.Start || start >= req.End {
+			continue
+		}
+		if req.Limit > 0 && len(resp.Traces) == int(req.Limit) {
+			break
+		}
+		resp.Traces = append(resp.Traces, &tempopb.TraceSearchMetadata{
+			TraceID:           fmt.Sprintf("%08x", start),
+			StartTimeUnixNano: uint64(start) * 1e9,
+		})
+	}
+	return resp, nil
+}
+
+func searchStarts(resp *tempopb.SearchResponse) []uint32 {
+	starts := make([]uint32, 0, len(resp.Traces))
+	for _, trace := range resp.Traces {
+		starts = append(starts, uint32(trace.StartTimeUnixNano/1e9))
+	}
+	return starts
+}
+
+func equalStarts(a, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSearchWindowsMaxWindowKeepsLimit(t *testing.T) {
+	// every trace is in the newest of four windows
+	dataset := &searchDataset{starts: []uint32{390, 391, 392, 393, 394, 395}, limits: map[searchWindow][]uint32{}}
+	b := &Backend{
+		querier:          &fakeQuerier{searchFn: dataset.search},
+		searchShards:     1,
+		shardConcurrency: 2,
+	}
+
+	req := &tempopb.SearchRequest{Start: 0, End: 400, Limit: 4}
+	resp, err := b.searchWindows(context.Background(), req, splitSearchWindow(req.Start, req.End, 100, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []uint32{395, 394, 393, 392}; !equalStarts(searchStarts(resp), expected) {
+		t.Errorf("expected traces starting at %v, got %v", expected, searchStarts(resp))
+	}
+	if len(dataset.limits) != 4 {
+		t.Fatalf("expected 4 windows to be searched, got %v", dataset.limits)
+	}
+	for window, limits := range dataset.limits {
+		if len(limits) != 1 || limits[0] != 4 {
+			t.Errorf("expected window %v to be searched once with the whole limit, got %v", window, limits)
+		}
+	}
+	if resp.Metrics.InspectedTraces != 4 {
+		t.Errorf("expected metrics of 4 searches, got %d", resp.Metrics.InspectedTraces)
+	}
+}
+
+func TestSearchWindowsShardsShareLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		starts   []uint32
+		expected []uint32
+		// limits every shard was asked for, newest shard first
+		limits [][]uint32
+	}{
+		{
+			name:     "evenly spread",
+			starts:   []uint32{10, 11, 110, 111, 210, 211, 310, 311},
+			expected: []uint32{311, 310, 211, 210, 111, 110, 11, 10},
+			limits:   [][]uint32{{2}, {2}, {2}, {2}},
+		},
+		{
+			name:     "sparse shards",
+			starts:   []uint32{10, 11, 12, 13, 14, 15, 16, 17, 310},
+			expected: []uint32{310, 17, 16, 15, 14, 13, 12, 11},
+			// the newest shard has a single trace and the middle ones none, the oldest is asked
+			// for what they left unused
+			limits: [][]uint32{{2}, {2}, {2}, {2, 7}},
+		},
+		{
+			name:     "fewer traces than the limit",
+			starts:   []uint32{10, 11, 12, 310},
+			expected: []uint32{310, 12, 11, 10},
+			limits:   [][]uint32{{2}, {2}, {2}, {2, 7}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dataset := &searchDataset{starts: tc.starts, limits: map[searchWindow][]uint32{}}
+			b := &Backend{
+				querier:          &fakeQuerier{searchFn: dataset.search},
+				searchShards:     4,
+				shardConcurrency: 4,
+			}
+
+			req := &tempopb.SearchRequest{Start: 0, End: 400, Limit: 8}
+			windows := splitSearchWindow(req.Start, req.End, 0, 4)
+			resp, err := b.searchWindows(context.Background(), req, windows)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !equalStarts(searchStarts(resp), tc.expected) {
+				t.Errorf("expected traces starting at %v, got %v", tc.expected, searchStarts(resp))
+			}
+			for i, window := range windows {
+				if !equalStarts(dataset.limits[window], tc.limits[i]) {
+					t.Errorf("expected window %v to be asked for %v, got %v", window, tc.limits[i], dataset.limits[window])
+				}
+			}
+		})
+	}
+}
+
+func TestSearchWindowsError(t *testing.T) {
+	errSearch := fmt.Errorf("search failed")
+	b := &Backend{
+		querier: &fakeQuerier{searchFn: func(req *tempopb.SearchRequest) (*tempopb.SearchResponse, error) {
+			if req.Start == 0 {
+				return nil, errSearch
+			}
+			return &tempopb.SearchResponse{}, nil
+		}},
+		searchShards:     1,
+		shardConcurrency: 1,
+	}
+
+	req := &tempopb.SearchRequest{Start: 0, End: 400, Limit: 8}
+	if _, err := b.searchWindows(context.Background(), req, splitSearchWindow(req.Start, req.End, 100, 1)); err != errSearch {
+		t.Fatalf("expected %v, got %v", errSearch, err)
+	}
+}
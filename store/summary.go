@@ -0,0 +1,49 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/grafana/tempo/pkg/tempopb"
+
+	jaeger "github.com/jaegertracing/jaeger/model"
+)
+
+const (
+	// summaryTag marks the synthetic root span of a trace summary
+	summaryTag = "tempo-query.summary"
+
+	summaryProcessID = "p1"
+)
+
+// summaryTraces builds a trace per search result, holding a single root span made up from the
+// root service, root name, start time and duration Tempo reports for the trace.
+func summaryTraces(results []*tempopb.TraceSearchMetadata) ([]*jaeger.Trace, error) {
+	traces := make([]*jaeger.Trace, 0, len(results))
+	for _, result := range results {
+		traceID, err := jaeger.TraceIDFromString(result.TraceID)
+		if err != nil {
+			return nil, fmt.Errorf("could not convert traceID into Jaeger's traceID %w", err)
+		}
+
+		process := &jaeger.Process{ServiceName: result.RootServiceName}
+		traces = append(traces, &jaeger.Trace{
+			Spans: []*jaeger.Span{{
+				TraceID: traceID,
+				// the root span ID isn't part of the search results
+				SpanID:        jaeger.SpanID(traceID.Low),
+				OperationName: result.RootTraceName,
+				StartTime:     time.Unix(0, int64(result.StartTimeUnixNano)),
+				Duration:      time.Duration(result.DurationMs) * time.Millisecond,
+				Tags:          []jaeger.KeyValue{jaeger.Bool(summaryTag, true)},
+				Process:       process,
+				ProcessID:     summaryProcessID,
+			}},
+			ProcessMap: []jaeger.Trace_ProcessMapping{{
+				ProcessID: summaryProcessID,
+				Process:   *process,
+			}},
+		})
+	}
+	return traces, nil
+}
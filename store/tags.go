@@ -0,0 +1,63 @@
+package store
+
+import (
+	"strings"
+)
+
+// builtinTagRules translate the tags Jaeger users search for into the names and values Tempo
+// indexes them under.
+var builtinTagRules = []TagRule{
+	{
+		// spans without an error are mostly unset rather than ok, so error=false has no
+		// single status to search for
+		Tag:       "error",
+		Attribute: "status.code",
+		Values:    map[string]string{"true": "error"},
+	},
+	{
+		Tag:       "otel.status_code",
+		Attribute: "status.code",
+		Values:    map[string]string{"ERROR": "error", "OK": "ok", "UNSET": "unset"},
+	},
+	{
+		Tag:       "span.kind",
+		Attribute: "kind",
+	},
+}
+
+// tagTranslator rewrites the tags of a Jaeger search into Tempo attributes. It is keyed by
+// Jaeger tag.
+type tagTranslator map[string]TagRule
+
+// newTagTranslator combines the built-in rules with the given ones, which take precedence.
+func newTagTranslator(rules []TagRule) tagTranslator {
+	t := tagTranslator{}
+	for _, rule := range append(builtinTagRules[:len(builtinTagRules):len(builtinTagRules)], rules...) {
+		// viper lowercases the keys of maps read from the configuration, so values are matched
+		// regardless of case
+		values := make(map[string]string, len(rule.Values))
+		for from, to := range rule.Values {
+			values[strings.ToLower(from)] = to
+		}
+		rule.Values = values
+		t[rule.Tag] = rule
+	}
+	return t
+}
+
+// translate returns the Tempo attribute and value to search for a Jaeger tag with. Tags without a
+// rule are searched as they are.
+func (t tagTranslator) translate(tag, value string) (string, string) {
+	rule, ok := t[tag]
+	if !ok {
+		return tag, value
+	}
+
+	if rule.Attribute != "" {
+		tag = rule.Attribute
+	}
+	if translated, ok := rule.Values[strings.ToLower(value)]; ok {
+		value = translated
+	}
+	return tag, value
+}
@@ -0,0 +1,202 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gogo/protobuf/jsonpb"
+	"github.com/grafana/tempo/pkg/tempopb"
+	"github.com/hashicorp/go-hclog"
+
+	"go.opentelemetry.io/collector/model/otlp"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+const (
+	JSONTypeHeaderValue = "application/json"
+
+	buildInfoPath = "/api/status/buildinfo"
+	// buildInfoTimeout bounds the probe, so an unreachable Tempo doesn't hold up startup
+	buildInfoTimeout = 10 * time.Second
+)
+
+// tempoVersion is the major and minor version of a Tempo release.
+type tempoVersion struct {
+	major int
+	minor int
+}
+
+func (v tempoVersion) atLeast(major, minor int) bool {
+	return v.major > major || (v.major == major && v.minor >= minor)
+}
+
+// parseTempoVersion parses versions such as "2.5.0", "v1.4.1" or "2.3.0-rc.1".
+func parseTempoVersion(version string) (tempoVersion, bool) {
+	parts := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 3)
+	if len(parts) < 2 {
+		return tempoVersion{}, false
+	}
+
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return tempoVersion{}, false
+	}
+	minor, err := strconv.Atoi(strings.TrimRight(strings.SplitN(parts[1], "-", 2)[0], "+"))
+	if err != nil {
+		return tempoVersion{}, false
+	}
+
+	return tempoVersion{major: major, minor: minor}, true
+}
+
+// tempoAPI describes how a Tempo version serves traces by ID.
+type tempoAPI struct {
+	// traceByIDPath is formatted with the trace ID
+	traceByIDPath string
+	accept        string
+	// decode decodes a protobuf body, JSON bodies are told apart by their content type
+	decode func(body []byte) (pdata.Traces, error)
+}
+
+var (
+	// tempoAPIV1 serves OTLP traces on /api/traces, as JSON only before Tempo 1.0.
+	tempoAPIV1 = &tempoAPI{
+		traceByIDPath: "/api/traces/%s",
+		accept:        ProtobufTypeHeaderValue,
+		decode:        otlp.NewProtobufTracesUnmarshaler().UnmarshalTraces,
+	}
+	// tempoAPIV2 wraps traces in a tempopb.TraceByIDResponse on /api/v2/traces.
+	tempoAPIV2 = &tempoAPI{
+		traceByIDPath: "/api/v2/traces/%s",
+		accept:        ProtobufTypeHeaderValue,
+		decode:        decodeTraceByIDResponse,
+	}
+)
+
+// tempoAPIForVersion returns the API of a Tempo version.
+func tempoAPIForVersion(version tempoVersion) *tempoAPI {
+	if version.atLeast(2, 5) {
+		return tempoAPIV2
+	}
+	return tempoAPIV1
+}
+
+// probeTempoVersion asks Tempo for its version, which picks the API to talk to it with and the
+// features it supports. It returns false if the version can't be told.
+func probeTempoVersion(tempoBackend string, logger hclog.Logger) (tempoVersion, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), buildInfoTimeout)
+	defer cancel()
+
+	version, err := fetchTempoVersion(ctx, tempoBackend)
+	if err != nil {
+		logger.Warn("failed to detect tempo version, assuming the v1 api", "error", err)
+		return tempoVersion{}, false
+	}
+
+	parsed, ok := parseTempoVersion(version)
+	if !ok {
+		logger.Warn("unknown tempo version, assuming the v1 api", "version", version)
+		return tempoVersion{}, false
+	}
+
+	logger.Info("detected tempo version", "version", version)
+	return parsed, true
+}
+
+func fetchTempoVersion(ctx context.Context, tempoBackend string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+tempoBackend+buildInfoPath, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed GET to tempo %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("error reading response from tempo: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", resp.Status, body)
+	}
+
+	var buildInfo struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(body, &buildInfo); err != nil {
+		return "", fmt.Errorf("error unmarshaling tempo build info: %w", err)
+	}
+	return buildInfo.Version, nil
+}
+
+// decodeTrace decodes the body of a trace by ID response.
+func (api *tempoAPI) decodeTrace(contentType string, body []byte) (pdata.Traces, error) {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil && mediaType == JSONTypeHeaderValue {
+		return decodeTraceJSON(body)
+	}
+	return api.decode(body)
+}
+
+func decodeTraceByIDResponse(body []byte) (pdata.Traces, error) {
+	var resp tempopb.TraceByIDResponse
+	if err := resp.Unmarshal(body); err != nil {
+		return pdata.Traces{}, err
+	}
+	if resp.Trace == nil {
+		return pdata.NewTraces(), nil
+	}
+
+	return tempoTraceToOTLP(resp.Trace)
+}
+
+func tempoTraceToOTLP(trace *tempopb.Trace) (pdata.Traces, error) {
+	// tempopb.Trace shares its wire format with OTLP's TracesData
+	body, err := trace.Marshal()
+	if err != nil {
+		return pdata.Traces{}, err
+	}
+	return otlp.NewProtobufTracesUnmarshaler().UnmarshalTraces(body)
+}
+
+// decodeTraceJSON decodes a JSON tempopb.Trace, as Tempo's jsonpb writes it with base64 IDs,
+// whose resource spans are called batches or resourceSpans by newer versions, and which may be
+// wrapped in a TraceByIDResponse.
+func decodeTraceJSON(body []byte) (pdata.Traces, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return pdata.Traces{}, err
+	}
+
+	if trace, ok := fields["trace"]; ok {
+		return decodeTraceJSON(trace)
+	}
+
+	resourceSpans, ok := fields["batches"]
+	if !ok {
+		resourceSpans, ok = fields["resourceSpans"]
+	}
+	if !ok || bytes.Equal(resourceSpans, []byte("null")) {
+		return pdata.NewTraces(), nil
+	}
+
+	tempoJSON, err := json.Marshal(map[string]json.RawMessage{"batches": resourceSpans})
+	if err != nil {
+		return pdata.Traces{}, err
+	}
+	var trace tempopb.Trace
+	if err := jsonpb.Unmarshal(bytes.NewReader(tempoJSON), &trace); err != nil {
+		return pdata.Traces{}, err
+	}
+	return tempoTraceToOTLP(&trace)
+}
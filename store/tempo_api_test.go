@@ -0,0 +1,189 @@
+package store
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gogo/protobuf/jsonpb"
+	"github.com/grafana/tempo/pkg/tempopb"
+
+	jaeger "github.com/jaegertracing/jaeger/model"
+	jaeger_spanstore "github.com/jaegertracing/jaeger/storage/spanstore"
+
+	"go.opentelemetry.io/collector/model/otlp"
+)
+
+var testTraceID = jaeger.NewTraceID(0x0102030405060708, 0x090a0b0c0d0e0f10)
+
+// testTempoTrace returns a trace of two spans in the encodings Tempo serves it in.
+func testTempoTrace(t *testing.T) (protobuf []byte, trace *tempopb.Trace, json string) {
+	t.Helper()
+
+	traces, err := batchToTraces(&jaeger.Batch{
+		Process: jaeger.NewProcess("frontend", nil),
+		Spans: []*jaeger.Span{
+			{TraceID: testTraceID, SpanID: jaeger.NewSpanID(1), OperationName: "GET /"},
+			{TraceID: testTraceID, SpanID: jaeger.NewSpanID(2), OperationName: "SELECT"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	protobuf, err = otlp.NewProtobufTracesMarshaler().MarshalTraces(traces)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trace = &tempopb.Trace{}
+	if err := trace.Unmarshal(protobuf); err != nil {
+		t.Fatal(err)
+	}
+	json, err = (&jsonpb.Marshaler{}).MarshalToString(trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return protobuf, trace, json
+}
+
+func TestParseTempoVersion(t *testing.T) {
+	tests := []struct {
+		version  string
+		expected tempoVersion
+		ok       bool
+		api      *tempoAPI
+	}{
+		{version: "v1.4.1", expected: tempoVersion{major: 1, minor: 4}, ok: true, api: tempoAPIV1},
+		{version: "1.5.0", expected: tempoVersion{major: 1, minor: 5}, ok: true, api: tempoAPIV1},
+		{version: "2.3.0-rc.1", expected: tempoVersion{major: 2, minor: 3}, ok: true, api: tempoAPIV1},
+		{version: "2.4", expected: tempoVersion{major: 2, minor: 4}, ok: true, api: tempoAPIV1},
+		{version: "2.5.0", expected: tempoVersion{major: 2, minor: 5}, ok: true, api: tempoAPIV2},
+		{version: "v3.0.0", expected: tempoVersion{major: 3, minor: 0}, ok: true, api: tempoAPIV2},
+		{version: "garbage"},
+		{version: "2"},
+		{version: "main-4b3a1f2"},
+		{version: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.version, func(t *testing.T) {
+			version, ok := parseTempoVersion(tc.version)
+			if ok != tc.ok || version != tc.expected {
+				t.Fatalf("expected %v %v, got %v %v", tc.expected, tc.ok, version, ok)
+			}
+			if ok && tempoAPIForVersion(version) != tc.api {
+				t.Errorf("expected api %s, got %s", tc.api.traceByIDPath, tempoAPIForVersion(version).traceByIDPath)
+			}
+		})
+	}
+}
+
+func TestDecodeTrace(t *testing.T) {
+	protobuf, trace, json := testTempoTrace(t)
+
+	envelope, err := (&tempopb.TraceByIDResponse{Trace: trace}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		api         *tempoAPI
+		contentType string
+		body        []byte
+		spans       int
+	}{
+		{name: "v1 protobuf", api: tempoAPIV1, contentType: ProtobufTypeHeaderValue, body: protobuf, spans: 2},
+		{name: "v2 envelope", api: tempoAPIV2, contentType: ProtobufTypeHeaderValue, body: envelope, spans: 2},
+		{name: "json batches", api: tempoAPIV1, contentType: JSONTypeHeaderValue, body: []byte(json), spans: 2},
+		{
+			name:        "json resource spans",
+			api:         tempoAPIV1,
+			contentType: JSONTypeHeaderValue + "; charset=utf-8",
+			body:        []byte(strings.Replace(json, `"batches"`, `"resourceSpans"`, 1)),
+			spans:       2,
+		},
+		{name: "json wrapped trace", api: tempoAPIV2, contentType: JSONTypeHeaderValue, body: []byte(`{"trace":` + json + `}`), spans: 2},
+		{name: "json null trace", api: tempoAPIV2, contentType: JSONTypeHeaderValue, body: []byte(`{"trace":null}`)},
+		{name: "json empty trace", api: tempoAPIV1, contentType: JSONTypeHeaderValue, body: []byte(`{}`)},
+		{name: "json null batches", api: tempoAPIV1, contentType: JSONTypeHeaderValue, body: []byte(`{"batches":null}`)},
+		{name: "v2 empty envelope", api: tempoAPIV2, contentType: ProtobufTypeHeaderValue, body: nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			traces, err := tc.api.decodeTrace(tc.contentType, tc.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if traces.SpanCount() != tc.spans {
+				t.Fatalf("expected %d spans, got %d", tc.spans, traces.SpanCount())
+			}
+			if tc.spans == 0 {
+				return
+			}
+
+			spans := traces.ResourceSpans().At(0).InstrumentationLibrarySpans().At(0).Spans()
+			if traceID := spans.At(0).TraceID().HexString(); traceID != testTraceID.String() {
+				t.Errorf("expected trace id %s, got %s", testTraceID, traceID)
+			}
+		})
+	}
+}
+
+func TestHTTPQuerierFindTraceByID(t *testing.T) {
+	_, trace, json := testTempoTrace(t)
+	envelope, err := (&tempopb.TraceByIDResponse{Trace: trace}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        []byte
+		notFound    bool
+	}{
+		{name: "trace", status: http.StatusOK, contentType: ProtobufTypeHeaderValue, body: envelope},
+		{name: "json trace", status: http.StatusOK, contentType: JSONTypeHeaderValue, body: []byte(`{"trace":` + json + `}`)},
+		{name: "null trace", status: http.StatusOK, contentType: JSONTypeHeaderValue, body: []byte(`{"trace":null}`), notFound: true},
+		{name: "empty trace", status: http.StatusOK, contentType: JSONTypeHeaderValue, body: []byte(`{"batches":[]}`), notFound: true},
+		{name: "empty envelope", status: http.StatusOK, contentType: ProtobufTypeHeaderValue, body: nil, notFound: true},
+		{name: "not found", status: http.StatusNotFound, notFound: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/api/v2/traces/"+testTraceID.String() {
+					http.NotFound(w, r)
+					return
+				}
+				if tc.contentType != "" {
+					w.Header().Set(ContentTypeHeaderKey, tc.contentType)
+				}
+				w.WriteHeader(tc.status)
+				_, _ = w.Write(tc.body)
+			}))
+			defer server.Close()
+
+			q := &httpQuerier{tempoBackend: strings.TrimPrefix(server.URL, "http://"), api: tempoAPIV2}
+			traces, err := q.findTraceByID(context.Background(), testTraceID, searchWindow{})
+			if tc.notFound {
+				if err != jaeger_spanstore.ErrTraceNotFound {
+					t.Fatalf("expected %v, got %v", jaeger_spanstore.ErrTraceNotFound, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if traces.SpanCount() != 2 {
+				t.Errorf("expected 2 spans, got %d", traces.SpanCount())
+			}
+		})
+	}
+}
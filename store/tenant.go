@@ -0,0 +1,31 @@
+package store
+
+import (
+	"encoding/hex"
+	"path/filepath"
+)
+
+// directory name for the data of requests without a tenant, never a valid hex encoding
+const defaultTenantDirectory = "default"
+
+// tenantDirectory returns the directory under dir holding the files of a tenant. Tenant ids are
+// not trusted as path elements, so they are hex encoded.
+func tenantDirectory(dir string, tenantID string) string {
+	if tenantID == "" {
+		return filepath.Join(dir, defaultTenantDirectory)
+	}
+	return filepath.Join(dir, hex.EncodeToString([]byte(tenantID)))
+}
+
+// tenantFromDirectory is the inverse of tenantDirectory for the base name of a directory.
+func tenantFromDirectory(name string) (string, bool) {
+	if name == defaultTenantDirectory {
+		return "", true
+	}
+
+	decoded, err := hex.DecodeString(name)
+	if err != nil {
+		return "", false
+	}
+	return string(decoded), true
+}
@@ -0,0 +1,116 @@
+package store
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	jaeger "github.com/jaegertracing/jaeger/model"
+)
+
+var (
+	metricTraceCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "jaeger_tempo",
+		Name:      "trace_cache_lookups_total",
+		Help:      "Total number of trace cache lookups by result, hit or miss.",
+	}, []string{"result"})
+)
+
+type cachedTrace struct {
+	key     traceKey
+	trace   *jaeger.Trace
+	expires time.Time
+}
+
+// traceCache keeps the most recently fetched traces, keyed by tenant and trace ID so tenants never
+// see each other's traces. Traces that ended within recentWindow may still be receiving spans, so
+// they are kept for recentTTL instead of ttl. Cached traces are shared and must not be modified.
+type traceCache struct {
+	size         int
+	ttl          time.Duration
+	recentTTL    time.Duration
+	recentWindow time.Duration
+
+	mtx    sync.Mutex
+	traces map[traceKey]*list.Element
+	lru    *list.List
+}
+
+func newTraceCache(cfg TraceCacheConfig) *traceCache {
+	return &traceCache{
+		size:         cfg.Size,
+		ttl:          cfg.TTL,
+		recentTTL:    cfg.RecentTTL,
+		recentWindow: cfg.RecentWindow,
+		traces:       map[traceKey]*list.Element{},
+		lru:          list.New(),
+	}
+}
+
+func (c *traceCache) get(ctx context.Context, traceID jaeger.TraceID) (*jaeger.Trace, bool) {
+	tenantID, _ := extractTenantID(ctx)
+	key := traceKey{tenantID: tenantID, traceID: traceID}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	elem, ok := c.traces[key]
+	if ok && time.Now().After(elem.Value.(*cachedTrace).expires) {
+		c.remove(elem)
+		ok = false
+	}
+	if !ok {
+		metricTraceCacheLookups.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+
+	metricTraceCacheLookups.WithLabelValues("hit").Inc()
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*cachedTrace).trace, true
+}
+
+func (c *traceCache) add(ctx context.Context, traceID jaeger.TraceID, trace *jaeger.Trace) {
+	tenantID, _ := extractTenantID(ctx)
+	key := traceKey{tenantID: tenantID, traceID: traceID}
+
+	now := time.Now()
+	ttl := c.ttl
+	if traceEnd(trace).After(now.Add(-c.recentWindow)) {
+		ttl = c.recentTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if elem, ok := c.traces[key]; ok {
+		c.remove(elem)
+	}
+
+	c.traces[key] = c.lru.PushFront(&cachedTrace{key: key, trace: trace, expires: now.Add(ttl)})
+	if c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *traceCache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.traces, elem.Value.(*cachedTrace).key)
+}
+
+// traceEnd returns the time the last span of a trace ended.
+func traceEnd(trace *jaeger.Trace) time.Time {
+	var end time.Time
+	for _, span := range trace.Spans {
+		if spanEnd := span.StartTime.Add(span.Duration); spanEnd.After(end) {
+			end = spanEnd
+		}
+	}
+	return end
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/common/user"
+
+	jaeger "github.com/jaegertracing/jaeger/model"
+)
+
+// testCachedTrace returns a trace of one span that ended at end.
+func testCachedTrace(traceID jaeger.TraceID, end time.Time) *jaeger.Trace {
+	return &jaeger.Trace{Spans: []*jaeger.Span{
+		{TraceID: traceID, SpanID: jaeger.NewSpanID(1), StartTime: end.Add(-time.Second), Duration: time.Second},
+	}}
+}
+
+func TestTraceCacheTenants(t *testing.T) {
+	c := newTraceCache(TraceCacheConfig{Size: 10, TTL: time.Hour})
+	tenant := user.InjectOrgID(context.Background(), "tenant")
+	other := user.InjectOrgID(context.Background(), "other")
+
+	traceID := jaeger.NewTraceID(0, 1)
+	trace := testCachedTrace(traceID, time.Now().Add(-time.Hour))
+	c.add(tenant, traceID, trace)
+
+	if cached, ok := c.get(tenant, traceID); !ok || cached != trace {
+		t.Fatalf("expected the trace to be cached for its tenant, got %v %v", cached, ok)
+	}
+	if _, ok := c.get(other, traceID); ok {
+		t.Error("expected the trace not to be cached for another tenant")
+	}
+	if _, ok := c.get(context.Background(), traceID); ok {
+		t.Error("expected the trace not to be cached without a tenant")
+	}
+}
+
+func TestTraceCacheEviction(t *testing.T) {
+	c := newTraceCache(TraceCacheConfig{Size: 2, TTL: time.Hour})
+	ctx := context.Background()
+	end := time.Now().Add(-time.Hour)
+
+	for id := uint64(1); id <= 2; id++ {
+		c.add(ctx, jaeger.NewTraceID(0, id), testCachedTrace(jaeger.NewTraceID(0, id), end))
+	}
+	// looking up the first trace makes the second the least recently used
+	if _, ok := c.get(ctx, jaeger.NewTraceID(0, 1)); !ok {
+		t.Fatal("expected the first trace to be cached")
+	}
+	c.add(ctx, jaeger.NewTraceID(0, 3), testCachedTrace(jaeger.NewTraceID(0, 3), end))
+
+	for id, cached := range map[uint64]bool{1: true, 2: false, 3: true} {
+		if _, ok := c.get(ctx, jaeger.NewTraceID(0, id)); ok != cached {
+			t.Errorf("expected trace %d cached %v, got %v", id, cached, ok)
+		}
+	}
+	if c.lru.Len() != 2 || len(c.traces) != 2 {
+		t.Errorf("expected 2 cached traces, got %d in the lru and %d in the index", c.lru.Len(), len(c.traces))
+	}
+}
+
+func TestTraceCacheTTL(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     TraceCacheConfig
+		end     time.Duration
+		cached  bool
+		expires time.Duration
+	}{
+		{
+			name:    "old trace",
+			cfg:     TraceCacheConfig{TTL: time.Hour, RecentTTL: time.Minute, RecentWindow: 10 * time.Minute},
+			end:     -time.Hour,
+			cached:  true,
+			expires: time.Hour,
+		},
+		{
+			name:    "recent trace",
+			cfg:     TraceCacheConfig{TTL: time.Hour, RecentTTL: time.Minute, RecentWindow: 10 * time.Minute},
+			end:     -time.Minute,
+			cached:  true,
+			expires: time.Minute,
+		},
+		{
+			name: "recent trace without recent ttl",
+			cfg:  TraceCacheConfig{TTL: time.Hour, RecentWindow: 10 * time.Minute},
+			end:  -time.Minute,
+		},
+		{
+			name: "without ttl",
+			cfg:  TraceCacheConfig{RecentTTL: time.Minute, RecentWindow: 10 * time.Minute},
+			end:  -time.Hour,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.cfg.Size = 10
+			c := newTraceCache(tc.cfg)
+			ctx := context.Background()
+			traceID := jaeger.NewTraceID(0, 1)
+
+			start := time.Now()
+			c.add(ctx, traceID, testCachedTrace(traceID, start.Add(tc.end)))
+			if _, ok := c.get(ctx, traceID); ok != tc.cached {
+				t.Fatalf("expected cached %v, got %v", tc.cached, ok)
+			}
+			if !tc.cached {
+				if c.lru.Len() != 0 {
+					t.Errorf("expected nothing to be cached, got %d traces", c.lru.Len())
+				}
+				return
+			}
+
+			entry := c.traces[traceKey{traceID: traceID}].Value.(*cachedTrace)
+			if expires := entry.expires.Sub(start); expires < tc.expires || expires > tc.expires+time.Second {
+				t.Errorf("expected the trace to expire in %s, expires in %s", tc.expires, expires)
+			}
+
+			// the trace is dropped once it expires
+			entry.expires = time.Now().Add(-time.Second)
+			if _, ok := c.get(ctx, traceID); ok {
+				t.Error("expected the expired trace not to be served")
+			}
+			if c.lru.Len() != 0 || len(c.traces) != 0 {
+				t.Errorf("expected the expired trace to be removed, got %d traces", c.lru.Len())
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/grafana/tempo/pkg/tempopb"
+
+	jaeger "github.com/jaegertracing/jaeger/model"
+)
+
+// traceHintSlack widens the window of a hint, as spans of a trace may start before its root span
+// and outlive it, and the clocks of services drift.
+const traceHintSlack = 5 * time.Minute
+
+type traceKey struct {
+	tenantID string
+	traceID  jaeger.TraceID
+}
+
+type traceHint struct {
+	key    traceKey
+	window searchWindow
+}
+
+// traceHints remembers when the traces returned by searches happened, so fetching one of them
+// later only has to look at the blocks of that time. The least recently used hints are dropped
+// once size hints are held.
+type traceHints struct {
+	size int
+
+	mtx   sync.Mutex
+	hints map[traceKey]*list.Element
+	lru   *list.List
+}
+
+func newTraceHints(size int) *traceHints {
+	return &traceHints{
+		size:  size,
+		hints: map[traceKey]*list.Element{},
+		lru:   list.New(),
+	}
+}
+
+// add remembers the time of the traces in a search response.
+func (h *traceHints) add(ctx context.Context, resp *tempopb.SearchResponse) {
+	tenantID, _ := extractTenantID(ctx)
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	for _, trace := range resp.Traces {
+		traceID, err := jaeger.TraceIDFromString(trace.TraceID)
+		if err != nil || trace.StartTimeUnixNano == 0 {
+			continue
+		}
+
+		start := time.Unix(0, int64(trace.StartTimeUnixNano))
+		end := start.Add(time.Duration(trace.DurationMs) * time.Millisecond)
+		window := searchWindow{
+			start: uint32(start.Add(-traceHintSlack).Unix()),
+			end:   uint32(end.Add(traceHintSlack).Unix()),
+		}
+
+		key := traceKey{tenantID: tenantID, traceID: traceID}
+		if elem, ok := h.hints[key]; ok {
+			elem.Value.(*traceHint).window = window
+			h.lru.MoveToFront(elem)
+			continue
+		}
+
+		h.hints[key] = h.lru.PushFront(&traceHint{key: key, window: window})
+		if h.lru.Len() > h.size {
+			oldest := h.lru.Back()
+			h.lru.Remove(oldest)
+			delete(h.hints, oldest.Value.(*traceHint).key)
+		}
+	}
+}
+
+// get returns the window a trace is expected in.
+func (h *traceHints) get(ctx context.Context, traceID jaeger.TraceID) (searchWindow, bool) {
+	tenantID, _ := extractTenantID(ctx)
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	elem, ok := h.hints[traceKey{tenantID: tenantID, traceID: traceID}]
+	if !ok {
+		return searchWindow{}, false
+	}
+	h.lru.MoveToFront(elem)
+	return elem.Value.(*traceHint).window, true
+}
@@ -0,0 +1,194 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/weaveworks/common/user"
+)
+
+type valuesCacheKey struct {
+	tenantID string
+	request  string
+}
+
+// valuesRefresh is how a cached list is kept up to date.
+type valuesRefresh int
+
+const (
+	// refreshBackground reloads a list every interval for as long as it is in use.
+	refreshBackground valuesRefresh = iota
+	// refreshOnDemand reloads a list in the background when it is asked for more than an interval
+	// after it was loaded, for lists that are too expensive to keep reloading.
+	refreshOnDemand
+)
+
+type valuesCacheEntry struct {
+	values   interface{}
+	load     func(ctx context.Context) (interface{}, error)
+	refresh  valuesRefresh
+	loaded   time.Time
+	lastUsed time.Time
+	// loading is set while an on demand reload is running
+	loading bool
+}
+
+// valuesCache keeps the lists the Jaeger UI asks for on every page load, such as services and
+// operations, per tenant. Once a list has been loaded it is always served from the cache and
+// reloaded in the background, either every interval or when asked for after the interval, so a
+// slow Tempo doesn't hold up the UI. Every reload is bounded by timeout. Lists that weren't asked
+// for within idleTimeout are dropped.
+type valuesCache struct {
+	interval    time.Duration
+	idleTimeout time.Duration
+	timeout     time.Duration
+	logger      hclog.Logger
+
+	mtx     sync.Mutex
+	entries map[valuesCacheKey]*valuesCacheEntry
+	closed  bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newValuesCache(cfg ValuesCacheConfig, logger hclog.Logger) *valuesCache {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &valuesCache{
+		interval:    cfg.RefreshInterval,
+		idleTimeout: cfg.IdleTimeout,
+		timeout:     cfg.Timeout,
+		logger:      logger,
+		entries:     map[valuesCacheKey]*valuesCacheEntry{},
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+
+	c.wg.Add(1)
+	go c.run()
+
+	return c
+}
+
+// get returns the cached list of a request, loading it with load if it isn't cached yet. load is
+// kept to reload the list as refresh says, so it must only depend on the context it is given.
+func (c *valuesCache) get(ctx context.Context, request string, refresh valuesRefresh, load func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	tenantID, _ := extractTenantID(ctx)
+	key := valuesCacheKey{tenantID: tenantID, request: request}
+
+	c.mtx.Lock()
+	entry, ok := c.entries[key]
+	if ok {
+		now := time.Now()
+		entry.lastUsed = now
+		values := entry.values
+		if entry.refresh == refreshOnDemand && !entry.loading && !c.closed && now.Sub(entry.loaded) >= c.interval {
+			entry.loading = true
+			c.wg.Add(1)
+			go func() {
+				defer c.wg.Done()
+				c.reload(key, entry)
+
+				c.mtx.Lock()
+				entry.loading = false
+				c.mtx.Unlock()
+			}()
+		}
+		c.mtx.Unlock()
+		return values, nil
+	}
+	c.mtx.Unlock()
+
+	values, err := load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	c.mtx.Lock()
+	c.entries[key] = &valuesCacheEntry{values: values, load: load, refresh: refresh, loaded: now, lastUsed: now}
+	c.mtx.Unlock()
+
+	return values, nil
+}
+
+func (c *valuesCache) run() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			c.refresh()
+		}
+	}
+}
+
+// refresh reloads every list in use that is refreshed in the background and drops the idle ones.
+func (c *valuesCache) refresh() {
+	idleSince := time.Now().Add(-c.idleTimeout)
+
+	c.mtx.Lock()
+	keys := make([]valuesCacheKey, 0, len(c.entries))
+	for key, entry := range c.entries {
+		if entry.lastUsed.Before(idleSince) {
+			delete(c.entries, key)
+			continue
+		}
+		if entry.refresh == refreshBackground {
+			keys = append(keys, key)
+		}
+	}
+	c.mtx.Unlock()
+
+	for _, key := range keys {
+		if c.ctx.Err() != nil {
+			return
+		}
+
+		c.mtx.Lock()
+		entry, ok := c.entries[key]
+		c.mtx.Unlock()
+		if !ok {
+			continue
+		}
+
+		c.reload(key, entry)
+	}
+}
+
+// reload loads a list again. A list that fails to reload is served stale until the next reload.
+func (c *valuesCache) reload(key valuesCacheKey, entry *valuesCacheEntry) {
+	ctx, cancel := context.WithTimeout(c.ctx, c.timeout)
+	defer cancel()
+	if key.tenantID != "" {
+		ctx = user.InjectOrgID(ctx, key.tenantID)
+	}
+
+	values, err := entry.load(ctx)
+	if err != nil {
+		c.logger.Error("failed to refresh cached values", "tenant", key.tenantID, "request", key.request, "error", err)
+		return
+	}
+
+	c.mtx.Lock()
+	entry.values = values
+	entry.loaded = time.Now()
+	c.mtx.Unlock()
+}
+
+func (c *valuesCache) close() {
+	c.mtx.Lock()
+	c.closed = true
+	c.mtx.Unlock()
+
+	c.cancel()
+	c.wg.Wait()
+}
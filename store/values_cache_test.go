@@ -0,0 +1,140 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// countingLoader returns how often it has been called, and hangs until its context ends when hang is set.
+type countingLoader struct {
+	mtx   sync.Mutex
+	calls int
+	hang  bool
+}
+
+func (l *countingLoader) load(ctx context.Context) (interface{}, error) {
+	l.mtx.Lock()
+	l.calls++
+	calls, hang := l.calls, l.hang
+	l.mtx.Unlock()
+
+	if hang {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return calls, nil
+}
+
+func (l *countingLoader) count() int {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.calls
+}
+
+func (l *countingLoader) setHang(hang bool) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.hang = hang
+}
+
+// newTestValuesCache returns a cache that is only refreshed when the test calls refresh.
+func newTestValuesCache(interval, timeout time.Duration) *valuesCache {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &valuesCache{
+		interval:    interval,
+		idleTimeout: time.Hour,
+		timeout:     timeout,
+		logger:      hclog.NewNullLogger(),
+		entries:     map[valuesCacheKey]*valuesCacheEntry{},
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+}
+
+func TestValuesCacheRefreshTimeout(t *testing.T) {
+	c := newTestValuesCache(time.Hour, 50*time.Millisecond)
+	defer c.close()
+
+	loader := &countingLoader{}
+	if _, err := c.get(context.Background(), "services", refreshBackground, loader.load); err != nil {
+		t.Fatal(err)
+	}
+
+	loader.setHang(true)
+	start := time.Now()
+	c.refresh()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the refresh to give up after its timeout, took %s", elapsed)
+	}
+
+	// the list is served stale
+	values, err := c.get(context.Background(), "services", refreshBackground, loader.load)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values != 1 {
+		t.Errorf("expected the list of the first load, got %v", values)
+	}
+}
+
+func TestValuesCacheRefreshOnDemand(t *testing.T) {
+	c := newTestValuesCache(time.Hour, time.Second)
+	defer c.close()
+
+	services := &countingLoader{}
+	operations := &countingLoader{}
+	if _, err := c.get(context.Background(), "services", refreshBackground, services.load); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.get(context.Background(), "operations/frontend/", refreshOnDemand, operations.load); err != nil {
+		t.Fatal(err)
+	}
+
+	c.refresh()
+	if services.count() != 2 {
+		t.Errorf("expected services to be reloaded in the background, loaded %d times", services.count())
+	}
+	if operations.count() != 1 {
+		t.Errorf("expected operations not to be reloaded in the background, loaded %d times", operations.count())
+	}
+
+	// asking for the operations within the interval serves them from the cache
+	if _, err := c.get(context.Background(), "operations/frontend/", refreshOnDemand, operations.load); err != nil {
+		t.Fatal(err)
+	}
+	c.wg.Wait()
+	if operations.count() != 1 {
+		t.Errorf("expected operations not to be reloaded within the interval, loaded %d times", operations.count())
+	}
+
+	// asking for them after the interval serves them stale and reloads them
+	c.mtx.Lock()
+	for _, entry := range c.entries {
+		entry.loaded = entry.loaded.Add(-2 * time.Hour)
+	}
+	c.mtx.Unlock()
+
+	values, err := c.get(context.Background(), "operations/frontend/", refreshOnDemand, operations.load)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values != 1 {
+		t.Errorf("expected the stale list, got %v", values)
+	}
+	c.wg.Wait()
+	if operations.count() != 2 {
+		t.Errorf("expected operations to be reloaded once, loaded %d times", operations.count())
+	}
+
+	values, err = c.get(context.Background(), "operations/frontend/", refreshOnDemand, operations.load)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values != 2 {
+		t.Errorf("expected the reloaded list, got %v", values)
+	}
+}
@@ -0,0 +1,398 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	jaeger "github.com/jaegertracing/jaeger/model"
+)
+
+const (
+	walSegmentExtension = ".wal"
+	// length and checksum of the record, see encodeWALRecord
+	walRecordHeaderSize = 8
+)
+
+var (
+	metricWALSizeBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "jaeger_tempo",
+		Name:      "wal_size_bytes",
+		Help:      "Size of the spans waiting in the write-ahead log.",
+	})
+	metricWALSegmentsDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "jaeger_tempo",
+		Name:      "wal_segments_dropped_total",
+		Help:      "Total number of write-ahead log segments removed to stay under the size cap.",
+	})
+	metricWALSpansReplayed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "jaeger_tempo",
+		Name:      "wal_spans_replayed_total",
+		Help:      "Total number of spans replayed from the write-ahead log to Tempo.",
+	})
+)
+
+var walCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+type walSegment struct {
+	index uint64
+	size  int64
+	// replayed is the offset up to which records have already been sent to Tempo
+	replayed int64
+}
+
+func (s walSegment) path(dir string) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", s.index, walSegmentExtension))
+}
+
+// wal is a write-ahead log of spans that could not be pushed to Tempo. Spans are appended to
+// numbered segment files that are replayed oldest first and removed once fully sent.
+//
+// Each record in a segment is laid out as
+//
+//	| length uint32 | crc32 uint32 | tenant length uint16 | tenant | jaeger.Batch |
+//
+// where length and checksum cover everything after the header. A record cut short by a crash
+// fails its checksum and is truncated away when the log is opened again.
+type wal struct {
+	dir            string
+	maxSegmentSize int64
+	maxSize        int64
+	logger         hclog.Logger
+
+	mtx      sync.Mutex
+	segments []*walSegment
+	// active is the segment being appended to, always the last of segments when set
+	active *os.File
+}
+
+func openWAL(cfg WALConfig, logger hclog.Logger) (*wal, error) {
+	if err := os.MkdirAll(cfg.Directory, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create wal directory: %w", err)
+	}
+
+	w := &wal{
+		dir:            cfg.Directory,
+		maxSegmentSize: cfg.MaxSegmentSize,
+		maxSize:        cfg.MaxSize,
+		logger:         logger,
+	}
+
+	entries, err := os.ReadDir(cfg.Directory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wal directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, walSegmentExtension) {
+			continue
+		}
+		index, err := strconv.ParseUint(strings.TrimSuffix(name, walSegmentExtension), 10, 64)
+		if err != nil {
+			logger.Warn("ignoring unexpected file in wal directory", "file", name)
+			continue
+		}
+		w.segments = append(w.segments, &walSegment{index: index})
+	}
+	sort.Slice(w.segments, func(i, j int) bool { return w.segments[i].index < w.segments[j].index })
+
+	for _, segment := range w.segments {
+		if err := w.recoverSegment(segment); err != nil {
+			return nil, err
+		}
+	}
+	w.updateSizeMetric()
+
+	return w, nil
+}
+
+// recoverSegment truncates a segment to its last complete record.
+func (w *wal) recoverSegment(segment *walSegment) error {
+	path := segment.path(w.dir)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read wal segment %s: %w", path, err)
+	}
+
+	valid, err := validWALPrefix(data)
+	if err != nil {
+		w.logger.Warn("truncating corrupt wal segment", "segment", path, "valid_bytes", valid, "error", err)
+		if err := os.Truncate(path, valid); err != nil {
+			return fmt.Errorf("failed to truncate wal segment %s: %w", path, err)
+		}
+	}
+
+	segment.size = valid
+	return nil
+}
+
+// append writes the spans of a tenant to the active segment, rotating it when it is full and
+// dropping the oldest segments when the log grows beyond its size cap.
+func (w *wal) append(tenantID string, spans []*jaeger.Span) error {
+	record, err := encodeWALRecord(tenantID, spans)
+	if err != nil {
+		return err
+	}
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if w.active != nil && w.segments[len(w.segments)-1].size+int64(len(record)) > w.maxSegmentSize {
+		if err := w.seal(); err != nil {
+			return err
+		}
+	}
+
+	if w.active == nil {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+
+	segment := w.segments[len(w.segments)-1]
+	if _, err := w.active.Write(record); err != nil {
+		return fmt.Errorf("failed to append to wal segment: %w", err)
+	}
+	if err := w.active.Sync(); err != nil {
+		return fmt.Errorf("failed to sync wal segment: %w", err)
+	}
+	segment.size += int64(len(record))
+
+	w.enforceMaxSize()
+	w.updateSizeMetric()
+
+	return nil
+}
+
+// rotate starts a new active segment after the newest existing one.
+func (w *wal) rotate() error {
+	segment := &walSegment{}
+	if len(w.segments) > 0 {
+		segment.index = w.segments[len(w.segments)-1].index + 1
+	}
+
+	f, err := os.OpenFile(segment.path(w.dir), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create wal segment: %w", err)
+	}
+
+	w.segments = append(w.segments, segment)
+	w.active = f
+	return nil
+}
+
+// seal closes the active segment so no further records are appended to it.
+func (w *wal) seal() error {
+	if w.active == nil {
+		return nil
+	}
+	err := w.active.Close()
+	w.active = nil
+	if err != nil {
+		return fmt.Errorf("failed to close wal segment: %w", err)
+	}
+	return nil
+}
+
+func (w *wal) enforceMaxSize() {
+	for w.size() > w.maxSize && len(w.segments) > 1 {
+		oldest := w.segments[0]
+		if err := os.Remove(oldest.path(w.dir)); err != nil {
+			w.logger.Error("failed to remove wal segment", "segment", oldest.path(w.dir), "error", err)
+			return
+		}
+		w.logger.Warn("wal is full, dropped oldest segment", "segment", oldest.path(w.dir), "bytes", oldest.size)
+		metricWALSegmentsDropped.Inc()
+		w.segments = w.segments[1:]
+	}
+}
+
+func (w *wal) size() int64 {
+	var size int64
+	for _, segment := range w.segments {
+		size += segment.size - segment.replayed
+	}
+	return size
+}
+
+func (w *wal) updateSizeMetric() {
+	metricWALSizeBytes.Set(float64(w.size()))
+}
+
+// replay sends every record in the log to fn, oldest first, and removes segments once all of
+// their records have been sent. It stops at the first error so the remaining records are retried
+// later in order. Progress within a segment is only kept in memory, so records may be sent twice
+// if the plugin restarts halfway through a segment.
+func (w *wal) replay(fn func(tenantID string, spans []*jaeger.Span) error) error {
+	for {
+		w.mtx.Lock()
+		if len(w.segments) == 0 {
+			w.mtx.Unlock()
+			return nil
+		}
+		// never read the segment that is being appended to
+		if w.active != nil && len(w.segments) == 1 {
+			if err := w.seal(); err != nil {
+				w.mtx.Unlock()
+				return err
+			}
+		}
+		segment := w.segments[0]
+		w.mtx.Unlock()
+
+		if err := w.replaySegment(segment, fn); err != nil {
+			return err
+		}
+
+		w.mtx.Lock()
+		if err := os.Remove(segment.path(w.dir)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			w.mtx.Unlock()
+			return fmt.Errorf("failed to remove replayed wal segment: %w", err)
+		}
+		// the segment may have been dropped by enforceMaxSize while it was replayed
+		if len(w.segments) > 0 && w.segments[0] == segment {
+			w.segments = w.segments[1:]
+		}
+		w.updateSizeMetric()
+		w.mtx.Unlock()
+	}
+}
+
+func (w *wal) replaySegment(segment *walSegment, fn func(tenantID string, spans []*jaeger.Span) error) error {
+	data, err := os.ReadFile(segment.path(w.dir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read wal segment: %w", err)
+	}
+
+	for offset := segment.replayed; offset < int64(len(data)); {
+		record, n, err := decodeWALRecord(data[offset:])
+		if err != nil {
+			// recovery on open guarantees complete records, skip whatever follows a bad one
+			w.logger.Error("skipping rest of corrupt wal segment", "segment", segment.path(w.dir), "offset", offset, "error", err)
+			return nil
+		}
+
+		if err := fn(record.tenantID, record.spans); err != nil {
+			return err
+		}
+		metricWALSpansReplayed.Add(float64(len(record.spans)))
+
+		offset += int64(n)
+		w.mtx.Lock()
+		segment.replayed = offset
+		w.updateSizeMetric()
+		w.mtx.Unlock()
+	}
+
+	return nil
+}
+
+func (w *wal) empty() bool {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	return len(w.segments) == 0
+}
+
+func (w *wal) close() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	return w.seal()
+}
+
+type walRecord struct {
+	tenantID string
+	spans    []*jaeger.Span
+}
+
+func encodeWALRecord(tenantID string, spans []*jaeger.Span) ([]byte, error) {
+	if len(tenantID) > 0xffff {
+		return nil, fmt.Errorf("tenant id is too long for the wal")
+	}
+
+	// spans keep their own process, so they can be regrouped when they are replayed
+	batch, err := (&jaeger.Batch{Spans: spans}).Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling spans for the wal: %w", err)
+	}
+
+	payloadSize := 2 + len(tenantID) + len(batch)
+	record := make([]byte, walRecordHeaderSize+payloadSize)
+	payload := record[walRecordHeaderSize:]
+	binary.BigEndian.PutUint16(payload, uint16(len(tenantID)))
+	copy(payload[2:], tenantID)
+	copy(payload[2+len(tenantID):], batch)
+
+	binary.BigEndian.PutUint32(record[0:], uint32(payloadSize))
+	binary.BigEndian.PutUint32(record[4:], crc32.Checksum(payload, walCRCTable))
+
+	return record, nil
+}
+
+// decodeWALRecord decodes the record at the start of data and returns the number of bytes it spans.
+func decodeWALRecord(data []byte) (walRecord, int, error) {
+	if len(data) < walRecordHeaderSize {
+		return walRecord{}, 0, errors.New("incomplete wal record header")
+	}
+
+	payloadSize := int(binary.BigEndian.Uint32(data[0:]))
+	checksum := binary.BigEndian.Uint32(data[4:])
+	if len(data)-walRecordHeaderSize < payloadSize {
+		return walRecord{}, 0, errors.New("incomplete wal record")
+	}
+
+	payload := data[walRecordHeaderSize : walRecordHeaderSize+payloadSize]
+	if crc32.Checksum(payload, walCRCTable) != checksum {
+		return walRecord{}, 0, errors.New("wal record checksum mismatch")
+	}
+	if len(payload) < 2 {
+		return walRecord{}, 0, errors.New("wal record is missing the tenant")
+	}
+
+	tenantSize := int(binary.BigEndian.Uint16(payload))
+	if len(payload) < 2+tenantSize {
+		return walRecord{}, 0, errors.New("wal record tenant is truncated")
+	}
+
+	var batch jaeger.Batch
+	if err := batch.Unmarshal(payload[2+tenantSize:]); err != nil {
+		return walRecord{}, 0, fmt.Errorf("error unmarshalling wal record: %w", err)
+	}
+
+	return walRecord{
+		tenantID: string(payload[2 : 2+tenantSize]),
+		spans:    batch.Spans,
+	}, walRecordHeaderSize + payloadSize, nil
+}
+
+// validWALPrefix returns the length of the complete records at the start of data and the error
+// that ended them, if any.
+func validWALPrefix(data []byte) (int64, error) {
+	var offset int64
+
+	for offset < int64(len(data)) {
+		_, n, err := decodeWALRecord(data[offset:])
+		if err != nil {
+			return offset, err
+		}
+		offset += int64(n)
+	}
+
+	return offset, nil
+}
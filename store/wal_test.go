@@ -0,0 +1,284 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+
+	jaeger "github.com/jaegertracing/jaeger/model"
+)
+
+func testWALSpans(ids ...uint64) []*jaeger.Span {
+	spans := make([]*jaeger.Span, 0, len(ids))
+	for _, id := range ids {
+		spans = append(spans, &jaeger.Span{
+			TraceID:       jaeger.NewTraceID(0, id),
+			SpanID:        jaeger.NewSpanID(id),
+			OperationName: "op",
+			Process:       &jaeger.Process{ServiceName: "svc"},
+		})
+	}
+	return spans
+}
+
+func openTestWAL(t *testing.T, cfg WALConfig) *wal {
+	t.Helper()
+	w, err := openWAL(cfg, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatalf("failed to open wal: %v", err)
+	}
+	return w
+}
+
+// replayedSpans replays the whole log and returns the span IDs it sent per tenant, in order.
+func replayedSpans(t *testing.T, w *wal) []string {
+	t.Helper()
+	var replayed []string
+	err := w.replay(func(tenantID string, spans []*jaeger.Span) error {
+		for _, span := range spans {
+			replayed = append(replayed, tenantID+"/"+span.SpanID.String())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to replay wal: %v", err)
+	}
+	return replayed
+}
+
+func walSegmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*"+walSegmentExtension))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestWALRecoversTruncatedTail(t *testing.T) {
+	dir := t.TempDir()
+	cfg := WALConfig{Directory: dir, MaxSegmentSize: defaultWALMaxSegmentSize, MaxSize: defaultWALMaxSize}
+
+	w := openTestWAL(t, cfg)
+	if err := w.append("a", testWALSpans(1, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.append("b", testWALSpans(3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := walSegmentFiles(t, dir)
+	if len(files) != 1 {
+		t.Fatalf("expected 1 segment, got %d", len(files))
+	}
+	before, err := os.Stat(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate a crash halfway through writing a record
+	partial, err := encodeWALRecord("c", testWALSpans(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(files[0], os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(partial[:len(partial)-3]); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	w = openTestWAL(t, cfg)
+	after, err := os.Stat(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Size() != before.Size() {
+		t.Fatalf("expected segment to be truncated to %d bytes, got %d", before.Size(), after.Size())
+	}
+
+	// appending after recovery starts a new segment after the recovered one
+	if err := w.append("c", testWALSpans(5)); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"a/" + jaeger.NewSpanID(1).String(),
+		"a/" + jaeger.NewSpanID(2).String(),
+		"b/" + jaeger.NewSpanID(3).String(),
+		"c/" + jaeger.NewSpanID(5).String(),
+	}
+	if replayed := replayedSpans(t, w); !equalStrings(replayed, expected) {
+		t.Fatalf("expected replay %v, got %v", expected, replayed)
+	}
+	if !w.empty() {
+		t.Fatal("expected wal to be empty after replay")
+	}
+	if files := walSegmentFiles(t, dir); len(files) != 0 {
+		t.Fatalf("expected replayed segments to be removed, got %v", files)
+	}
+}
+
+func TestValidWALPrefix(t *testing.T) {
+	first, err := encodeWALRecord("a", testWALSpans(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := encodeWALRecord("b", testWALSpans(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	complete := append(append([]byte{}, first...), second...)
+
+	corrupt := append([]byte{}, complete...)
+	corrupt[len(corrupt)-1] ^= 0xff
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected int64
+		err      bool
+	}{
+		{name: "empty", data: nil, expected: 0},
+		{name: "complete", data: complete, expected: int64(len(complete))},
+		{name: "partial header", data: complete[:len(first)+3], expected: int64(len(first)), err: true},
+		{name: "partial payload", data: complete[:len(complete)-1], expected: int64(len(first)), err: true},
+		{name: "checksum mismatch", data: corrupt, expected: int64(len(first)), err: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			valid, err := validWALPrefix(tc.data)
+			if valid != tc.expected {
+				t.Errorf("expected %d valid bytes, got %d", tc.expected, valid)
+			}
+			if (err != nil) != tc.err {
+				t.Errorf("expected error %v, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestWALRotatesSegments(t *testing.T) {
+	record, err := encodeWALRecord("a", testWALSpans(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	// room for two records per segment
+	w := openTestWAL(t, WALConfig{Directory: dir, MaxSegmentSize: int64(2 * len(record)), MaxSize: defaultWALMaxSize})
+
+	var expected []string
+	for id := uint64(1); id <= 5; id++ {
+		if err := w.append("a", testWALSpans(id)); err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, "a/"+jaeger.NewSpanID(id).String())
+	}
+
+	if files := walSegmentFiles(t, dir); len(files) != 3 {
+		t.Fatalf("expected 3 segments, got %v", files)
+	}
+	if replayed := replayedSpans(t, w); !equalStrings(replayed, expected) {
+		t.Fatalf("expected replay %v, got %v", expected, replayed)
+	}
+}
+
+func TestWALEnforcesMaxSize(t *testing.T) {
+	record, err := encodeWALRecord("a", testWALSpans(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	// one record per segment and room for three of them
+	w := openTestWAL(t, WALConfig{Directory: dir, MaxSegmentSize: int64(len(record)), MaxSize: int64(3 * len(record))})
+
+	for id := uint64(1); id <= 5; id++ {
+		if err := w.append("a", testWALSpans(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if files := walSegmentFiles(t, dir); len(files) != 3 {
+		t.Fatalf("expected 3 segments, got %v", files)
+	}
+
+	// the oldest spans were dropped
+	expected := []string{
+		"a/" + jaeger.NewSpanID(3).String(),
+		"a/" + jaeger.NewSpanID(4).String(),
+		"a/" + jaeger.NewSpanID(5).String(),
+	}
+	if replayed := replayedSpans(t, w); !equalStrings(replayed, expected) {
+		t.Fatalf("expected replay %v, got %v", expected, replayed)
+	}
+}
+
+func TestWALReplayStopsAtFirstError(t *testing.T) {
+	record, err := encodeWALRecord("a", testWALSpans(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	w := openTestWAL(t, WALConfig{Directory: dir, MaxSegmentSize: int64(2 * len(record)), MaxSize: defaultWALMaxSize})
+
+	for id := uint64(1); id <= 4; id++ {
+		if err := w.append("a", testWALSpans(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	errPush := errors.New("push failed")
+	var sent []uint64
+	err = w.replay(func(_ string, spans []*jaeger.Span) error {
+		if spans[0].SpanID == jaeger.NewSpanID(2) {
+			return errPush
+		}
+		sent = append(sent, uint64(spans[0].SpanID))
+		return nil
+	})
+	if !errors.Is(err, errPush) {
+		t.Fatalf("expected replay to fail with %v, got %v", errPush, err)
+	}
+	if len(sent) != 1 || sent[0] != 1 {
+		t.Fatalf("expected only span 1 to be sent before the error, got %v", sent)
+	}
+	if w.empty() {
+		t.Fatal("expected wal to keep the spans that weren't sent")
+	}
+
+	// the next replay resumes at the record that failed, without resending the earlier one
+	expected := []string{
+		"a/" + jaeger.NewSpanID(2).String(),
+		"a/" + jaeger.NewSpanID(3).String(),
+		"a/" + jaeger.NewSpanID(4).String(),
+	}
+	if replayed := replayedSpans(t, w); !equalStrings(replayed, expected) {
+		t.Fatalf("expected replay %v, got %v", expected, replayed)
+	}
+}
@@ -0,0 +1,161 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	otgrpc "github.com/opentracing-contrib/go-grpc"
+	"github.com/opentracing/opentracing-go"
+	"github.com/weaveworks/common/user"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	jaeger "github.com/jaegertracing/jaeger/model"
+
+	ot_jaeger "github.com/open-telemetry/opentelemetry-collector-contrib/pkg/translator/jaeger"
+	"go.opentelemetry.io/collector/model/otlp"
+	"go.opentelemetry.io/collector/model/otlpgrpc"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+const (
+	writeProtocolGRPC = "grpc"
+	writeProtocolHTTP = "http"
+
+	ContentTypeHeaderKey    = "Content-Type"
+	OTLPProtobufHeaderValue = "application/x-protobuf"
+	otlpHTTPTracesPath      = "/v1/traces"
+)
+
+var errWriteDisabled = errors.New("span writing is disabled, set write.backend to forward spans to tempo")
+
+// spanExporter pushes OTLP traces to a Tempo distributor.
+type spanExporter interface {
+	export(ctx context.Context, traces pdata.Traces) error
+	close() error
+}
+
+func newSpanExporter(cfg WriteConfig) (spanExporter, error) {
+	switch cfg.Protocol {
+	case writeProtocolGRPC:
+		return newGRPCExporter(cfg.Backend)
+	case writeProtocolHTTP:
+		return newHTTPExporter(cfg.Backend), nil
+	default:
+		return nil, fmt.Errorf("unknown write protocol %q, must be one of %q or %q", cfg.Protocol, writeProtocolGRPC, writeProtocolHTTP)
+	}
+}
+
+// batchToTraces converts Jaeger batches into OTLP traces, the inverse of ot_jaeger.ProtoFromTraces.
+func batchToTraces(batches ...*jaeger.Batch) (pdata.Traces, error) {
+	// the translation removes the instrumentation library tags from the spans it is given, so it
+	// works on copies to leave the spans intact for the wal
+	copies := make([]*jaeger.Batch, len(batches))
+	for i, batch := range batches {
+		spans := make([]*jaeger.Span, len(batch.Spans))
+		for j, span := range batch.Spans {
+			spanCopy := *span
+			spanCopy.Tags = append([]jaeger.KeyValue(nil), span.Tags...)
+			spans[j] = &spanCopy
+		}
+		copies[i] = &jaeger.Batch{Spans: spans, Process: batch.Process}
+	}
+
+	traces, err := ot_jaeger.ProtoToTraces(copies)
+	if err != nil {
+		return pdata.Traces{}, fmt.Errorf("error translating jaeger batch to otlp traces: %w", err)
+	}
+	return traces, nil
+}
+
+type grpcExporter struct {
+	conn   *grpc.ClientConn
+	client otlpgrpc.TracesClient
+}
+
+func newGRPCExporter(endpoint string) (*grpcExporter, error) {
+	conn, err := grpc.Dial(endpoint,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(otgrpc.OpenTracingClientInterceptor(opentracing.GlobalTracer())),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial tempo distributor %s: %w", endpoint, err)
+	}
+
+	return &grpcExporter{
+		conn:   conn,
+		client: otlpgrpc.NewTracesClient(conn),
+	}, nil
+}
+
+func (e *grpcExporter) export(ctx context.Context, traces pdata.Traces) error {
+	if tenantID, found := extractTenantID(ctx); found {
+		var err error
+		ctx, err = user.InjectIntoGRPCRequest(user.InjectOrgID(ctx, tenantID))
+		if err != nil {
+			return err
+		}
+	}
+
+	req := otlpgrpc.NewTracesRequest()
+	req.SetTraces(traces)
+
+	if _, err := e.client.Export(ctx, req); err != nil {
+		return fmt.Errorf("failed to push spans to tempo: %w", err)
+	}
+	return nil
+}
+
+func (e *grpcExporter) close() error {
+	return e.conn.Close()
+}
+
+type httpExporter struct {
+	url string
+}
+
+func newHTTPExporter(endpoint string) *httpExporter {
+	return &httpExporter{
+		url: fmt.Sprintf("http://%s%s", endpoint, otlpHTTPTracesPath),
+	}
+}
+
+func (e *httpExporter) export(ctx context.Context, traces pdata.Traces) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "tempo-query.export")
+	defer span.Finish()
+
+	body, err := otlp.NewProtobufTracesMarshaler().MarshalTraces(traces)
+	if err != nil {
+		return fmt.Errorf("error marshalling otlp traces: %w", err)
+	}
+
+	req, err := newRequest(ctx, "POST", e.url, bytes.NewReader(body), span)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(ContentTypeHeaderKey, OTLPProtobufHeaderValue)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed POST to tempo %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("error reading response from Tempo: got %s", resp.Status)
+		}
+		return fmt.Errorf("failed to push spans to tempo: %s", body)
+	}
+
+	return nil
+}
+
+func (e *httpExporter) close() error {
+	return nil
+}